@@ -1,6 +1,12 @@
 package cgroups
 
 import (
+	"errors"
+	"os"
+	"path"
+
+	"golang.org/x/sys/unix"
+
 	"github.com/sirupsen/logrus"
 
 	"github.com/devhg/ddocker/cgroups/subsystems"
@@ -40,10 +46,47 @@ func (c *CgroupManager) Set(res *subsystems.ResourceConfig) error {
 	return nil
 }
 
-// 释放各个subsystem挂载的cgroup
+// Paths 返回cgroup在每个subsystem中的绝对路径，例如 /sys/fs/cgroup/memory/${path}
+func (c *CgroupManager) Paths() ([]string, error) {
+	var paths []string
+	for _, subSysIns := range subsystems.SubsystemIns {
+		p, err := subsystems.GetCgroupPath(subSysIns.Name(), c.Path, false)
+		if err != nil {
+			return nil, err
+		}
+		paths = append(paths, p)
+	}
+	return paths, nil
+}
+
+// Writable 判断当前用户能否在每个subsystem中创建这个cgroup，
+// rootless模式下只有管理员把cgroup子树委派给当前用户时才能使用cgroup
+func (c *CgroupManager) Writable() bool {
+	for _, subSysIns := range subsystems.SubsystemIns {
+		mountPoint := subsystems.FindCgroupMountPoint(subSysIns.Name())
+		if mountPoint == "" {
+			return false
+		}
+
+		// 从cgroup目录开始向上找到第一个已经存在的目录，当前用户要能在里面创建子目录
+		dir := path.Join(mountPoint, c.Path)
+		for {
+			if _, err := os.Stat(dir); err == nil || dir == mountPoint {
+				break
+			}
+			dir = path.Dir(dir)
+		}
+		if unix.Access(dir, unix.W_OK) != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// 释放各个subsystem挂载的cgroup，已经释放过的cgroup直接跳过
 func (c *CgroupManager) Destroy() {
 	for _, subSysIns := range subsystems.SubsystemIns {
-		if err := subSysIns.Remove(c.Path); err != nil {
+		if err := subSysIns.Remove(c.Path); err != nil && !errors.Is(err, os.ErrNotExist) {
 			logrus.Warnln(err)
 			// panic(err)
 		}
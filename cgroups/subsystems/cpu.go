@@ -43,9 +43,10 @@ func (c *CPUSubsystem) Apply(cgroupPath string, pid int) error {
 		return fmt.Errorf("get cgroup %s error: %v", cgroupPath, err)
 	}
 
-	// 把进程的PID写到cgroup的虚拟文件系统对应目录下的task文件中
-	// "/sys/fs/cgroup/cpu/${cgroupPath}/tasks"
-	dstFile := path.Join(subSysCgroupPath, "tasks")
+	// 把进程的PID写到cgroup的虚拟文件系统对应目录下的cgroup.procs文件中，
+	// 和tasks文件不同，会把进程的所有线程都加入cgroup
+	// "/sys/fs/cgroup/cpu/${cgroupPath}/cgroup.procs"
+	dstFile := path.Join(subSysCgroupPath, "cgroup.procs")
 	if err := ioutil.WriteFile(dstFile, []byte(strconv.Itoa(pid)), 0644); err != nil {
 		return fmt.Errorf("set cgroup proc failed %v", err)
 	}
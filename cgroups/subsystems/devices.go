@@ -0,0 +1,64 @@
+package subsystems
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"strconv"
+)
+
+// DevicesSubSystem 是 devices subsystem的实现，限制容器能访问的设备
+type DevicesSubSystem struct {
+}
+
+// Name 返回subsystem的名字
+func (d *DevicesSubSystem) Name() string {
+	return "devices"
+}
+
+// Set 先禁止访问所有设备，再依次放行配置中的设备规则，规则的格式是 "type major:minor access"，例如 "c 1:3 rwm"
+func (d *DevicesSubSystem) Set(cgroupPath string, res *ResourceConfig) error {
+	subSysCgroupPath, err := GetCgroupPath(d.Name(), cgroupPath, true)
+	if err != nil {
+		return fmt.Errorf("get cgroup %s error: %v", cgroupPath, err)
+	}
+
+	if len(res.Devices) == 0 {
+		return nil
+	}
+
+	if err := ioutil.WriteFile(path.Join(subSysCgroupPath, devicesDeny), []byte("a"), 0644); err != nil {
+		return fmt.Errorf("deny all devices failed %v", err)
+	}
+	for _, rule := range res.Devices {
+		if err := ioutil.WriteFile(path.Join(subSysCgroupPath, devicesAllow), []byte(rule), 0644); err != nil {
+			return fmt.Errorf("allow device %q failed %v", rule, err)
+		}
+	}
+	return nil
+}
+
+// Apply 将进程添加到某个cgroup中
+func (d *DevicesSubSystem) Apply(cgroupPath string, pid int) error {
+	subSysCgroupPath, err := GetCgroupPath(d.Name(), cgroupPath, false)
+	if err != nil {
+		return fmt.Errorf("get cgroup %s error: %v", cgroupPath, err)
+	}
+
+	// "/sys/fs/cgroup/devices/${cgroupPath}/cgroup.procs"
+	dstFile := path.Join(subSysCgroupPath, "cgroup.procs")
+	if err := ioutil.WriteFile(dstFile, []byte(strconv.Itoa(pid)), 0644); err != nil {
+		return fmt.Errorf("set cgroup proc failed %v", err)
+	}
+	return nil
+}
+
+// Remove 移除某个cgroup
+func (d *DevicesSubSystem) Remove(cgroupPath string) error {
+	subSysCgroupPath, err := GetCgroupPath(d.Name(), cgroupPath, false)
+	if err != nil {
+		return fmt.Errorf("get cgroup %s error: %w", cgroupPath, err)
+	}
+	return os.Remove(subSysCgroupPath)
+}
@@ -45,9 +45,10 @@ func (m *MemorySubSystem) Apply(cgroupPath string, pid int) error {
 		return fmt.Errorf("get cgroup %s error: %v", cgroupPath, err)
 	}
 
-	// 把进程的PID写到cgroup的虚拟文件系统对应目录下的task文件中
-	// "/sys/fs/cgroup/memory/${cgroupPath}/tasks"
-	dstFile := path.Join(subSysCgroupPath, "tasks")
+	// 把进程的PID写到cgroup的虚拟文件系统对应目录下的cgroup.procs文件中，
+	// 和tasks文件不同，会把进程的所有线程都加入cgroup
+	// "/sys/fs/cgroup/memory/${cgroupPath}/cgroup.procs"
+	dstFile := path.Join(subSysCgroupPath, "cgroup.procs")
 	logrus.Info(dstFile)
 	if err := ioutil.WriteFile(dstFile, []byte(strconv.Itoa(pid)), 0644); err != nil {
 		return fmt.Errorf("set cgroup proc failed %v", err)
@@ -59,7 +60,7 @@ func (m *MemorySubSystem) Apply(cgroupPath string, pid int) error {
 func (m *MemorySubSystem) Remove(cgroupPath string) error {
 	subSysCgroupPath, err := GetCgroupPath(m.Name(), cgroupPath, false)
 	if err != nil {
-		return fmt.Errorf("get cgroup %s error: %v", cgroupPath, err)
+		return fmt.Errorf("get cgroup %s error: %w", cgroupPath, err)
 	}
 	return os.Remove(subSysCgroupPath)
 }
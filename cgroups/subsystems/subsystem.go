@@ -5,6 +5,8 @@ type ResourceConfig struct {
 	MemoryLimit string
 	CPUShare    string
 	CPUSet      string
+	// devices子系统放行的设备规则，为空时不限制
+	Devices []string
 }
 
 // SubSystemer 接口，每个Subsystem可以实现下面4个接口
@@ -31,10 +33,13 @@ var SubsystemIns = []SubSystemer{
 	// Q: {"level":"info","msg":"set cgroup proc failed write /sys/fs/cgroup/cpuset/ddocker-cgroup/tasks: no space left on device","time":"2021-07-25T16:33:09+08:00"}
 	// A: ?
 	&MemorySubSystem{},
+	&DevicesSubSystem{},
 }
 
 const (
 	memoryLimitInBytes = "memory.limit_in_bytes"
 	cpuShare           = "cpu.shares"
 	cpuSet             = "cpuset.cpus"
+	devicesAllow       = "devices.allow"
+	devicesDeny        = "devices.deny"
 )
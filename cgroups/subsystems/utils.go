@@ -20,13 +20,13 @@ func GetCgroupPath(subsystem string, cgroupPath string, autoCreate bool) (string
 	}
 
 	if autoCreate && os.IsNotExist(err) {
-		if err = os.Mkdir(absCgroupPath, 0755); err != nil {
+		if err = os.MkdirAll(absCgroupPath, 0755); err != nil {
 			return "", fmt.Errorf("cgroup create error %v", err)
 		}
 		return absCgroupPath, nil
 	}
 
-	return "", fmt.Errorf("cgroup path error %v", err)
+	return "", fmt.Errorf("cgroup path error %w", err)
 }
 
 // FindCgroupMountPoint
@@ -0,0 +1,167 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/urfave/cli"
+
+	"github.com/devhg/ddocker/container"
+	"github.com/devhg/ddocker/util"
+)
+
+const defaultDetachKeys = "ctrl-p,ctrl-q"
+
+var AttachCommand = cli.Command{
+	Name:  "attach",
+	Usage: "attach local standard input and output to a running container",
+	Flags: []cli.Flag{
+		cli.StringFlag{
+			Name:  "detach-keys",
+			Usage: "key sequence for detaching the container",
+			Value: defaultDetachKeys,
+		},
+	},
+	Action: func(ctx *cli.Context) error {
+		if len(ctx.Args()) < 1 {
+			return errors.New("missing containerID")
+		}
+
+		keys, err := parseDetachKeys(ctx.String("detach-keys"))
+		if err != nil {
+			return err
+		}
+		return attachContainer(ctx.Args().Get(0), keys)
+	},
+}
+
+// errDetached 在输入中读到detach按键序列时返回
+var errDetached = errors.New("detached from container")
+
+// attachContainer 连接容器监控进程的 attach.sock，把当前终端的输入发给容器，
+// 容器的输出打印到当前终端，输入detach按键序列时断开连接，容器继续运行
+func attachContainer(containerID string, detachKeys []byte) error {
+	cinfo := GetContainerInfo(containerID)
+	if cinfo == nil {
+		return fmt.Errorf("container[%v] not found", containerID)
+	}
+	if cinfo.Status != container.StatusRunning {
+		return fmt.Errorf("cannot attach to a %v container", cinfo.Status)
+	}
+
+	// /var/run/ddocker/${containerID}/attach.sock
+	sock := path.Join(container.DefaultInfoLocation, containerID, container.AttachSocketName)
+	conn, err := net.Dial("unix", sock)
+	if err != nil {
+		return fmt.Errorf("connect to container[%v] error: %v", containerID, err)
+	}
+	defer conn.Close()
+
+	// 有终端的容器需要把按键原样发过去，同时也才能识别出没有回车的detach按键
+	if cinfo.TTY && util.IsTerminal(os.Stdin.Fd()) {
+		restore, err := util.MakeRaw(os.Stdin.Fd())
+		if err != nil {
+			return err
+		}
+		defer restore()
+	}
+
+	outputDone := make(chan struct{})
+	go func() {
+		_, _ = io.Copy(os.Stdout, conn)
+		close(outputDone)
+	}()
+
+	inputDone := make(chan error, 1)
+	go func() {
+		inputDone <- copyUntilDetach(conn, os.Stdin, detachKeys)
+	}()
+
+	select {
+	case <-outputDone:
+		// 容器已经退出
+	case err := <-inputDone:
+		if err == errDetached {
+			fmt.Fprint(os.Stdout, "\r\n")
+			return nil
+		}
+		// 标准输入已经结束，继续输出直到容器退出
+		<-outputDone
+	}
+	return nil
+}
+
+// copyUntilDetach 把src复制到dst，直到src结束或者读到detach按键序列。
+// 部分匹配按键序列的输入会先暂存，确认不是detach之后再一起发送
+func copyUntilDetach(dst io.Writer, src io.Reader, keys []byte) error {
+	buf := make([]byte, 1024)
+	matched := 0
+	for {
+		n, err := src.Read(buf)
+		if n > 0 {
+			out := make([]byte, 0, n+matched)
+			for _, b := range buf[:n] {
+				if matched > 0 && b != keys[matched] {
+					out = append(out, keys[:matched]...)
+					matched = 0
+				}
+
+				if b == keys[matched] {
+					matched++
+					if matched == len(keys) {
+						if len(out) > 0 {
+							_, _ = dst.Write(out)
+						}
+						return errDetached
+					}
+					continue
+				}
+				out = append(out, b)
+			}
+			if len(out) > 0 {
+				if _, err := dst.Write(out); err != nil {
+					return err
+				}
+			}
+		}
+		if err != nil {
+			if matched > 0 {
+				_, _ = dst.Write(keys[:matched])
+			}
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+	}
+}
+
+// parseDetachKeys 解析 "ctrl-p,ctrl-q" 这样的按键序列，
+// 支持单个字符以及 ctrl-a ~ ctrl-z、ctrl-@、ctrl-[、ctrl-\、ctrl-]、ctrl-^、ctrl-_
+func parseDetachKeys(s string) ([]byte, error) {
+	var keys []byte
+	for _, key := range strings.Split(s, ",") {
+		switch {
+		case len(key) == 1:
+			keys = append(keys, key[0])
+		case strings.HasPrefix(key, "ctrl-") && len(key) == len("ctrl-")+1:
+			c := key[len(key)-1]
+			switch {
+			case c >= 'a' && c <= 'z':
+				keys = append(keys, c-'a'+1)
+			case c >= '@' && c <= '_':
+				keys = append(keys, c-'@')
+			default:
+				return nil, fmt.Errorf("invalid detach key: %s", key)
+			}
+		default:
+			return nil, fmt.Errorf("invalid detach key: %s", key)
+		}
+	}
+	return keys, nil
+}
@@ -2,7 +2,6 @@ package cmd
 
 import (
 	"errors"
-	"fmt"
 	"os/exec"
 	"path"
 
@@ -28,7 +27,11 @@ var CommitCommand = cli.Command{
 }
 
 func commitContainer(containerID, image string) {
-	mntURL := fmt.Sprintf(container.MntURL, containerID)
+	var idmap *container.IDMapping
+	if cinfo := GetContainerInfo(containerID); cinfo != nil {
+		idmap = cinfo.IDMapping
+	}
+	mntURL := container.MountPoint(containerID, idmap)
 	imageTar := path.Join(container.RootURL, image+".tar")
 	logrus.Infof("new image:%v", imageTar)
 
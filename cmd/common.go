@@ -2,13 +2,17 @@ package cmd
 
 import (
 	"encoding/json"
+	"fmt"
 	"io/ioutil"
 	"os"
 	"path"
+	"strconv"
+	"time"
 
 	"github.com/sirupsen/logrus"
 
 	"github.com/devhg/ddocker/container"
+	"github.com/devhg/ddocker/network"
 )
 
 func GetContainerPID(contianerID string) string {
@@ -36,6 +40,47 @@ func readContainerInfo(f os.FileInfo) (*container.ContainerInfo, error) {
 	return &info, nil
 }
 
+// listContainerInfos 读取 /var/run/ddocker/ 下所有容器的信息
+func listContainerInfos() []*container.ContainerInfo {
+	dir := container.DefaultInfoLocation
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		// 还没有创建过任何容器
+		if !os.IsNotExist(err) {
+			logrus.Errorf("read dir %s error[%v]", dir, err)
+		}
+		return nil
+	}
+
+	var infos []*container.ContainerInfo
+	for _, file := range files {
+		// 跳过网络配置这类不是容器的目录
+		configFile := path.Join(dir, file.Name(), container.ConfigName)
+		if _, err := os.Stat(configFile); os.IsNotExist(err) {
+			continue
+		}
+
+		info, err := readContainerInfo(file)
+		if err != nil {
+			logrus.Errorf("get container info error[%v]", err)
+			continue
+		}
+		infos = append(infos, info)
+	}
+	return infos
+}
+
+// containerRunning 判断容器是否还在运行，--rm 的容器退出后信息已经被删除，也返回false
+func containerRunning(contianerID string) bool {
+	fileInfo, err := os.Stat(path.Join(container.DefaultInfoLocation, contianerID))
+	if err != nil {
+		return false
+	}
+
+	info, err := readContainerInfo(fileInfo)
+	return err == nil && info.Status == container.StatusRunning
+}
+
 func GetContainerInfo(contianerID string) *container.ContainerInfo {
 	config := path.Join(container.DefaultInfoLocation, contianerID)
 	fileInfo, err := os.Stat(config)
@@ -67,17 +112,51 @@ func writeContainerInfo(contianerID string, info *container.ContainerInfo) error
 	return nil
 }
 
-func removeContainerInfo(contianerID string) {
-	// /var/run/ddocker/${containerID}/
-	dir := path.Join(container.DefaultInfoLocation, contianerID)
-	_, err := os.Stat(dir)
-	if err != nil || os.IsNotExist(err) {
-		logrus.Errorf("func[removeContainerInfo] error[%v]", err)
+// markContainerStopped 容器退出后，将容器状态记录为stopped
+func markContainerStopped(contianerID string) {
+	cinfo := GetContainerInfo(contianerID)
+	if cinfo == nil {
 		return
 	}
 
-	if err := os.RemoveAll(dir); err != nil {
-		logrus.Errorf("func[removeContainerInfo] error[%v]", err)
-		return
+	cinfo.Status = container.StatusStopped
+	cinfo.PID = ""
+	if err := writeContainerInfo(contianerID, cinfo); err != nil {
+		logrus.Errorf("func[markContainerStopped] error[%v]", err)
+	}
+}
+
+// cleanupContainer 清理容器占用的资源：网络端点(IP、端口映射的iptables规则)、
+// overlay的读写层和挂载点、volume的挂载点，以及容器的信息目录
+func cleanupContainer(cinfo *container.ContainerInfo) {
+	if cinfo.Network != "" && cinfo.IPAddress != "" {
+		if err := network.Init(); err != nil {
+			logrus.Errorf("func[cleanupContainer] init network error[%v]", err)
+		} else if err := network.Disconnect(cinfo.Network, cinfo); err != nil {
+			logrus.Errorf("func[cleanupContainer] disconnect network error[%v]", err)
+		}
+	}
+
+	container.DeleteWorkSpace(cinfo.ID, cinfo.Volume)
+	container.DeleteContainerInfo(cinfo.ID)
+}
+
+// containerCgroupPath 每个容器在各个subsystem的hierarchy下使用独立的cgroup
+func containerCgroupPath(contianerID string) string {
+	return path.Join("ddocker-cgroup", contianerID)
+}
+
+// parseTimeFilter 解析时间过滤条件，可以是相对现在的时间段(例如 10m、24h)，
+// RFC3339格式的时间点，或者unix时间戳
+func parseTimeFilter(value string) (time.Time, error) {
+	if d, err := time.ParseDuration(value); err == nil {
+		return time.Now().Add(-d), nil
+	}
+	if t, err := time.Parse(time.RFC3339Nano, value); err == nil {
+		return t, nil
+	}
+	if sec, err := strconv.ParseFloat(value, 64); err == nil {
+		return time.Unix(0, int64(sec*float64(time.Second))), nil
 	}
+	return time.Time{}, fmt.Errorf("invalid time %q, expect a duration, RFC3339 time or unix timestamp", value)
 }
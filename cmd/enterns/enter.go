@@ -0,0 +1,304 @@
+//go:build !cgo
+// +build !cgo
+
+package enterns
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"strconv"
+	"strings"
+	"syscall"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// UserNSSupported 不使用cgo时Go运行时已经启动了多个线程，setns进入user namespace会返回EINVAL，
+// exec 在启动 enterns 之前就根据它拒绝有user namespace的容器
+const UserNSSupported = false
+
+// Enter 是不使用cgo时进入容器执行命令的实现，和 setns.go 里的构造函数做同样的事情。
+// Go程序启动时已经是多线程的，不能对整个进程做setns，所以把当前goroutine锁定在一个线程上，
+// 先unshare(CLONE_FS)让这个线程有独立的根目录和工作目录，再对这个线程依次setns和chroot，
+// 之后从这个线程fork出来的用户命令就在容器的namespace里。返回值是用户命令的退出码。
+// 内核不允许多线程的进程进入user namespace，所以这种方式不能exec进 --userns-remap 和rootless模式的容器
+func Enter() int {
+	runtime.LockOSThread()
+	// 线程的namespace已经改变，不再解锁，goroutine退出时这个线程也随之销毁
+
+	pid := os.Getenv("ddocker_pid")
+
+	// 要执行的命令以参数列表的形式跟在 /proc/self/exe exec 后面
+	if len(os.Args) < 3 {
+		fmt.Fprintln(os.Stderr, "missing exec command")
+		return 1
+	}
+	argv := os.Args[2:]
+
+	if err := unix.Unshare(unix.CLONE_FS); err != nil {
+		fmt.Fprintf(os.Stderr, "unshare fs failed: %v\n", err)
+		return 1
+	}
+
+	// 先加入容器的cgroup，之后fork出来的用户命令也在同一个cgroup里。
+	// 和cgo的路径一样写cgroup.procs，整个进程的所有线程一起加入
+	if cgroups := os.Getenv("ddocker_exec_cgroups"); cgroups != "" {
+		pid := strconv.Itoa(os.Getpid())
+		for _, dir := range strings.Split(cgroups, ":") {
+			if err := writeFile(dir+"/cgroup.procs", pid); err != nil {
+				fmt.Fprintf(os.Stderr, "join cgroup %s failed: %v\n", dir, err)
+				return 1
+			}
+		}
+	}
+
+	// 进入mnt namespace之前打开容器的根目录，之后chroot到这里
+	root := fmt.Sprintf("/proc/%s/root", pid)
+	rootfd, err := unix.Open(root, unix.O_RDONLY|unix.O_DIRECTORY|unix.O_CLOEXEC, 0)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "open container root %s failed: %v\n", root, err)
+		return 1
+	}
+
+	// 多线程的进程不能进入user namespace，只有cgo构建的构造函数能在单线程时完成
+	if userns, err := os.Readlink(fmt.Sprintf("/proc/%s/ns/user", pid)); err != nil {
+		fmt.Fprintf(os.Stderr, "read container user namespace failed: %v\n", err)
+		return 1
+	} else if self, _ := os.Readlink("/proc/self/ns/user"); userns != self {
+		fmt.Fprintln(os.Stderr, "exec into a container with user namespace requires a cgo build")
+		return 1
+	}
+
+	for _, ns := range []string{"ipc", "uts", "net", "pid", "mnt"} {
+		nspath := fmt.Sprintf("/proc/%s/ns/%s", pid, ns)
+		fd, err := unix.Open(nspath, unix.O_RDONLY|unix.O_CLOEXEC, 0)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "open %s failed: %v\n", nspath, err)
+			return 1
+		}
+		// 任何一个namespace进入失败都不能继续执行用户命令
+		if err := unix.Setns(fd, 0); err != nil {
+			fmt.Fprintf(os.Stderr, "setns on %s namespace failed: %v\n", ns, err)
+			return 1
+		}
+		unix.Close(fd)
+	}
+
+	if err := chroot(rootfd); err != nil {
+		fmt.Fprintf(os.Stderr, "chroot to container root failed: %v\n", err)
+		return 1
+	}
+	unix.Close(rootfd)
+
+	if workdir := os.Getenv("ddocker_exec_workdir"); workdir != "" {
+		if err := unix.Chdir(workdir); err != nil {
+			fmt.Fprintf(os.Stderr, "chdir to %s failed: %v\n", workdir, err)
+			return 126
+		}
+	}
+
+	// 资源限制是进程级别的，fork出来的用户命令会继承。提高hard limit需要CAP_SYS_RESOURCE
+	if rlimits := os.Getenv("ddocker_exec_rlimits"); rlimits != "" {
+		if err := setRlimits(rlimits); err != nil {
+			fmt.Fprintf(os.Stderr, "set rlimits failed: %v\n", err)
+			return 126
+		}
+	}
+
+	// 用户命令从这个线程fork出来，会继承这个线程的bounding set
+	if caps := os.Getenv("ddocker_exec_caps"); caps != "" {
+		if err := dropCapabilities(caps); err != nil {
+			fmt.Fprintf(os.Stderr, "drop capabilities failed: %v\n", err)
+			return 126
+		}
+	}
+
+	if os.Getenv("ddocker_exec_no_new_privs") != "" {
+		if err := unix.Prctl(unix.PR_SET_NO_NEW_PRIVS, 1, 0, 0, 0); err != nil {
+			fmt.Fprintf(os.Stderr, "set no_new_privs failed: %v\n", err)
+			return 126
+		}
+	}
+	// 过滤器同样只作用于这个线程和从它fork出来的用户命令，需要在切换用户之前安装
+	if seccomp := os.Getenv("ddocker_exec_seccomp"); seccomp != "" {
+		if err := installSeccomp(seccomp); err != nil {
+			fmt.Fprintf(os.Stderr, "install seccomp filter failed: %v\n", err)
+			return 126
+		}
+	}
+
+	cmd := exec.Command(argv[0], argv[1:]...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.SysProcAttr = &syscall.SysProcAttr{}
+
+	// -it 时用户命令在容器的pid namespace里新建会话，并把pty设置成控制终端
+	if os.Getenv("ddocker_exec_tty") != "" {
+		cmd.SysProcAttr.Setsid = true
+		cmd.SysProcAttr.Setctty = true
+		cmd.SysProcAttr.Ctty = 0
+	}
+	if user := os.Getenv("ddocker_exec_user"); user != "" {
+		cred, err := parseUser(user)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "switch to user %s failed: %v\n", user, err)
+			return 126
+		}
+		cmd.SysProcAttr.Credential = cred
+	}
+
+	for _, env := range os.Environ() {
+		if !strings.HasPrefix(env, "ddocker_pid=") && !strings.HasPrefix(env, "ddocker_exec_") {
+			cmd.Env = append(cmd.Env, env)
+		}
+	}
+
+	err = cmd.Run()
+	if exitErr, ok := err.(*exec.ExitError); ok {
+		status := exitErr.Sys().(syscall.WaitStatus)
+		if status.Signaled() {
+			return 128 + int(status.Signal())
+		}
+		return status.ExitStatus()
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "exec %s failed: %v\n", argv[0], err)
+		if errors.Is(err, exec.ErrNotFound) || errors.Is(err, os.ErrNotExist) {
+			return 127
+		}
+		return 126
+	}
+	return 0
+}
+
+// setRlimits 设置exec传过来的资源限制，格式是 resource:soft:hard,...。
+// 使用syscall包设置，os/exec启动用户命令时就不会把RLIMIT_NOFILE恢复成Go运行时启动前的值
+func setRlimits(rlimits string) error {
+	for _, item := range strings.Split(rlimits, ",") {
+		fields := strings.Split(item, ":")
+		if len(fields) != 3 {
+			return unix.EINVAL
+		}
+		resource, err1 := strconv.Atoi(fields[0])
+		soft, err2 := strconv.ParseUint(fields[1], 10, 64)
+		hard, err3 := strconv.ParseUint(fields[2], 10, 64)
+		if err1 != nil || err2 != nil || err3 != nil {
+			return unix.EINVAL
+		}
+		if err := syscall.Setrlimit(resource, &syscall.Rlimit{Cur: soft, Max: hard}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// dropCapabilities 从当前线程的bounding set中去掉容器没有保留的capability，
+// mask的第n位对应编号为n的capability。同时清空inheritable集合，
+// 文件的inheritable capability不受bounding set限制
+func dropCapabilities(caps string) error {
+	mask, err := strconv.ParseUint(caps, 10, 64)
+	if err != nil {
+		return err
+	}
+
+	if err := unix.Prctl(unix.PR_CAP_AMBIENT, unix.PR_CAP_AMBIENT_CLEAR_ALL, 0, 0, 0); err != nil && err != unix.EINVAL {
+		return err
+	}
+	for n := uint(0); n < 64; n++ {
+		if mask&(1<<n) != 0 {
+			continue
+		}
+		if err := unix.Prctl(unix.PR_CAPBSET_DROP, uintptr(n), 0, 0, 0); err != nil {
+			// 超出当前内核支持的最大编号
+			if err == unix.EINVAL {
+				break
+			}
+			return err
+		}
+	}
+
+	hdr := unix.CapUserHeader{Version: unix.LINUX_CAPABILITY_VERSION_3}
+	var data [2]unix.CapUserData
+	if err := unix.Capget(&hdr, &data[0]); err != nil {
+		return err
+	}
+	data[0].Inheritable, data[1].Inheritable = 0, 0
+	return unix.Capset(&hdr, &data[0])
+}
+
+// installSeccomp 安装exec传过来的seccomp过滤器，每条指令编码成16个十六进制字符：code(4) jt(2) jf(2) k(8)
+func installSeccomp(hex string) error {
+	if len(hex) == 0 || len(hex)%16 != 0 {
+		return unix.EINVAL
+	}
+
+	filter := make([]unix.SockFilter, len(hex)/16)
+	for i := range filter {
+		ins := hex[16*i : 16*i+16]
+		code, err1 := strconv.ParseUint(ins[0:4], 16, 16)
+		jt, err2 := strconv.ParseUint(ins[4:6], 16, 8)
+		jf, err3 := strconv.ParseUint(ins[6:8], 16, 8)
+		k, err4 := strconv.ParseUint(ins[8:16], 16, 32)
+		if err1 != nil || err2 != nil || err3 != nil || err4 != nil {
+			return unix.EINVAL
+		}
+		filter[i] = unix.SockFilter{Code: uint16(code), Jt: uint8(jt), Jf: uint8(jf), K: uint32(k)}
+	}
+
+	prog := unix.SockFprog{Len: uint16(len(filter)), Filter: &filter[0]}
+	return unix.Prctl(unix.PR_SET_SECCOMP, unix.SECCOMP_MODE_FILTER, uintptr(unsafe.Pointer(&prog)), 0, 0)
+}
+
+func chroot(rootfd int) error {
+	if err := unix.Fchdir(rootfd); err != nil {
+		return err
+	}
+	if err := unix.Chroot("."); err != nil {
+		return err
+	}
+	return unix.Chdir("/")
+}
+
+// parseUser 解析 uid:gid[:附加组,...]，没有指定gid时使用0，和docker的行为一致
+func parseUser(user string) (*syscall.Credential, error) {
+	ids := strings.SplitN(user, ":", 3)
+	uid, err := strconv.ParseUint(ids[0], 10, 32)
+	if err != nil {
+		return nil, err
+	}
+	cred := &syscall.Credential{Uid: uint32(uid), Groups: []uint32{}}
+	if len(ids) > 1 {
+		gid, err := strconv.ParseUint(ids[1], 10, 32)
+		if err != nil {
+			return nil, err
+		}
+		cred.Gid = uint32(gid)
+	}
+	if len(ids) > 2 {
+		for _, g := range strings.Split(ids[2], ",") {
+			gid, err := strconv.ParseUint(g, 10, 32)
+			if err != nil {
+				return nil, err
+			}
+			cred.Groups = append(cred.Groups, uint32(gid))
+		}
+	}
+	return cred, nil
+}
+
+func writeFile(file, content string) error {
+	f, err := os.OpenFile(file, os.O_WRONLY, 0)
+	if err != nil {
+		return err
+	}
+	if _, err := f.WriteString(content); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
@@ -9,46 +9,386 @@ package enterns
 #include <stdlib.h>
 #include <string.h>
 #include <fcntl.h>
+#include <grp.h>
+#include <sys/ioctl.h>
+#include <sys/prctl.h>
+#include <sys/resource.h>
+#include <sys/syscall.h>
+#include <sys/stat.h>
+#include <sys/wait.h>
+#include <linux/capability.h>
+#include <linux/filter.h>
+#include <linux/seccomp.h>
+
+// 读取 /proc/self/cmdline，返回以NULL结尾的参数列表
+static char **read_cmdline(void) {
+	int fd = open("/proc/self/cmdline", O_RDONLY);
+	if (fd == -1) {
+		return NULL;
+	}
+
+	size_t cap = 4096, len = 0;
+	char *buf = malloc(cap);
+	ssize_t n;
+	while ((n = read(fd, buf + len, cap - len)) > 0) {
+		len += n;
+		if (len == cap) {
+			cap *= 2;
+			buf = realloc(buf, cap);
+		}
+	}
+	close(fd);
+
+	int argc = 0;
+	size_t i;
+	for (i = 0; i < len; i++) {
+		if (buf[i] == '\0') {
+			argc++;
+		}
+	}
+
+	char **argv = calloc(argc + 1, sizeof(char *));
+	char *p = buf;
+	for (i = 0; i < argc; i++) {
+		argv[i] = p;
+		p += strlen(p) + 1;
+	}
+	return argv;
+}
+
+// 把当前进程写入每个cgroup目录的cgroup.procs文件，目录之间用:分隔
+static int join_cgroups(const char *cgroups) {
+	char *dirs = strdup(cgroups);
+	char *saveptr;
+	char *dir;
+	for (dir = strtok_r(dirs, ":", &saveptr); dir; dir = strtok_r(NULL, ":", &saveptr)) {
+		char procs[1024];
+		snprintf(procs, sizeof(procs), "%s/cgroup.procs", dir);
+		FILE *f = fopen(procs, "w");
+		if (!f) {
+			fprintf(stderr, "open %s failed: %s\n", procs, strerror(errno));
+			free(dirs);
+			return -1;
+		}
+		fprintf(f, "%d", getpid());
+		if (fclose(f) == EOF) {
+			fprintf(stderr, "join cgroup %s failed: %s\n", dir, strerror(errno));
+			free(dirs);
+			return -1;
+		}
+	}
+	free(dirs);
+	return 0;
+}
+
+// 当前user namespace是否禁止了setgroups
+static int setgroups_denied(void) {
+	char buf[16] = {0};
+	int fd = open("/proc/self/setgroups", O_RDONLY);
+	if (fd == -1) {
+		return 0;
+	}
+	read(fd, buf, sizeof(buf) - 1);
+	close(fd);
+	return strncmp(buf, "deny", 4) == 0;
+}
+
+// 从bounding set中去掉容器没有保留的capability，mask的第n位对应编号为n的capability。
+// 之后execve的进程即使是root也不会拥有bounding set之外的capability。
+// 同时清空inheritable集合，文件的inheritable capability不受bounding set限制
+static int drop_capabilities(const char *caps) {
+	char *end;
+	unsigned long long mask = strtoull(caps, &end, 10);
+	if (*end != '\0') {
+		errno = EINVAL;
+		return -1;
+	}
+
+	// 清空ambient集合，老内核不支持时返回EINVAL
+	if (prctl(PR_CAP_AMBIENT, PR_CAP_AMBIENT_CLEAR_ALL, 0, 0, 0) == -1 && errno != EINVAL) {
+		return -1;
+	}
+	int cap;
+	for (cap = 0; cap < 64; cap++) {
+		if (mask & (1ULL << cap)) {
+			continue;
+		}
+		if (prctl(PR_CAPBSET_DROP, cap, 0, 0, 0) == -1) {
+			// 超出当前内核支持的最大编号
+			if (errno == EINVAL) {
+				break;
+			}
+			return -1;
+		}
+	}
+
+	struct __user_cap_header_struct hdr = { _LINUX_CAPABILITY_VERSION_3, 0 };
+	struct __user_cap_data_struct data[2];
+	if (syscall(SYS_capget, &hdr, data) == -1) {
+		return -1;
+	}
+	data[0].inheritable = 0;
+	data[1].inheritable = 0;
+	return syscall(SYS_capset, &hdr, data);
+}
+
+// 安装exec传过来的seccomp过滤器，每条指令编码成16个十六进制字符：code(4) jt(2) jf(2) k(8)
+static int install_seccomp(const char *hex) {
+	size_t len = strlen(hex);
+	if (len == 0 || len % 16 != 0) {
+		errno = EINVAL;
+		return -1;
+	}
+
+	size_t i, n = len / 16;
+	struct sock_filter *filter = calloc(n, sizeof(struct sock_filter));
+	if (!filter) {
+		return -1;
+	}
+	for (i = 0; i < n; i++) {
+		unsigned int code, jt, jf, k;
+		if (sscanf(hex + 16 * i, "%4x%2x%2x%8x", &code, &jt, &jf, &k) != 4) {
+			free(filter);
+			errno = EINVAL;
+			return -1;
+		}
+		filter[i].code = code;
+		filter[i].jt = jt;
+		filter[i].jf = jf;
+		filter[i].k = k;
+	}
+
+	struct sock_fprog prog = { .len = n, .filter = filter };
+	int ret = prctl(PR_SET_SECCOMP, SECCOMP_MODE_FILTER, &prog, 0, 0);
+	free(filter);
+	return ret;
+}
+
+// 设置容器的资源限制，格式是 resource:soft:hard,...
+static int set_rlimits(const char *rlimits) {
+	char *items = strdup(rlimits);
+	char *saveptr;
+	char *item;
+	for (item = strtok_r(items, ",", &saveptr); item; item = strtok_r(NULL, ",", &saveptr)) {
+		int resource;
+		unsigned long long soft, hard;
+		if (sscanf(item, "%d:%llu:%llu", &resource, &soft, &hard) != 3) {
+			free(items);
+			errno = EINVAL;
+			return -1;
+		}
+		struct rlimit rlim = { .rlim_cur = soft, .rlim_max = hard };
+		if (setrlimit(resource, &rlim) == -1) {
+			free(items);
+			return -1;
+		}
+	}
+	free(items);
+	return 0;
+}
+
+// 切换到 uid:gid[:附加组,...] 指定的用户，用户名已经由exec在容器的 /etc/passwd 中解析成数字。
+// 没有指定gid时使用0，和docker的行为一致
+static int switch_user(const char *user) {
+	char *end;
+	uid_t uid = strtoul(user, &end, 10);
+	gid_t gid = 0;
+	gid_t *groups = NULL;
+	size_t ngroups = 0;
+	if (*end == ':') {
+		gid = strtoul(end + 1, &end, 10);
+	}
+	if (*end == ':') {
+		// 每个附加组至少占两个字符，按剩余长度分配足够了
+		groups = calloc(strlen(end), sizeof(gid_t));
+		if (!groups) {
+			return -1;
+		}
+		do {
+			groups[ngroups++] = strtoul(end + 1, &end, 10);
+		} while (*end == ',');
+	}
+	if (*end != '\0') {
+		free(groups);
+		errno = EINVAL;
+		return -1;
+	}
+
+	// 先设置附加组，再依次设置gid和uid，设置uid之后就没有权限再修改gid了。
+	// rootless容器的user namespace禁止了setgroups，跳过附加组
+	int ret = setgroups(ngroups, groups);
+	free(groups);
+	if (ret == -1 && !setgroups_denied()) {
+		return -1;
+	}
+	if (setgid(gid) == -1 || setuid(uid) == -1) {
+		return -1;
+	}
+	return 0;
+}
 
 // __attribute__((constructor))指的是，一旦这个包被引用，那么这个函数
 // 就会被自动执行，类似构造函数，会在程序启动时执行
 __attribute__((constructor)) void enter_namespace(void) {
-	// 从环境变量获取 需要进入的 PID 和 command
+	// 从环境变量获取需要进入的 PID
 	char *ddocker_pid;
 	ddocker_pid = getenv("ddocker_pid");
-	if (ddocker_pid) {
-		// fprintf(stdout, "got ddocker_pid=%s\n", ddocker_pid);
-	} else {
-		// fprintf(stdout, "missing ddocker_pid env skip enterns");
+	if (!ddocker_pid) {
 		return;
 	}
 
-	char *ddocker_cmd;
-	ddocker_cmd = getenv("ddocker_cmd");
-	if (ddocker_cmd) {
-		// fprintf(stdout, "got ddocker_cmd=%s\n", ddocker_cmd);
-	} else {
-		// fprintf(stdout, "missing ddocker_cmd env skip enterns");
-		return;
+	// 要执行的命令以参数列表的形式跟在 /proc/self/exe exec 后面
+	char **argv = read_cmdline();
+	if (!argv || !argv[0] || !argv[1] || !argv[2]) {
+		fprintf(stderr, "missing exec command\n");
+		exit(1);
+	}
+	char **cmd = argv + 2;
+
+	// 先加入容器的cgroup，之后fork出来的用户命令也在同一个cgroup里
+	char *cgroups = getenv("ddocker_exec_cgroups");
+	if (cgroups && join_cgroups(cgroups) == -1) {
+		exit(1);
 	}
 
+	// 进入mnt namespace之前打开容器的根目录，之后chroot到这里
+	char path[1024];
+	snprintf(path, sizeof(path), "/proc/%s/root", ddocker_pid);
+	int rootfd = open(path, O_RDONLY | O_DIRECTORY);
+	if (rootfd == -1) {
+		fprintf(stderr, "open container root %s failed: %s\n", path, strerror(errno));
+		exit(1);
+	}
+
+	// 容器使用了user namespace时要先进入user namespace，才有权限进入它拥有的其他namespace。
+	// 先把所有namespace文件打开，避免切换user namespace之后没有权限访问宿主机的 /proc
 	int i;
-	char nspath[1024];
-	char *namespaces[] = { "ipc", "uts", "net", "pid", "mnt" };
-	for (i = 0; i < 5; i++) {
-		sprintf(nspath, "/proc/%s/ns/%s", ddocker_pid, namespaces[i]);
-		int fd = open(nspath, O_RDONLY);
-		// 调用setns系统调用，进入对应的 namespace
-		if (setns(fd, 0) == -1) {
-			// fprintf(stderr, "setns on %s namespace failed: %s\n", namespaces[i], strerror(errno));
-		} else {
-			// fprintf(stdout, "setns on %s namespace succeeded\n", namespaces[i]);
-		}
-		close(fd);
-	}
-	int res = system(ddocker_cmd);
-	exit(0);
-	return;
+	int joined_userns = 0;
+	char *namespaces[] = { "user", "ipc", "uts", "net", "pid", "mnt" };
+	int fds[6];
+	for (i = 0; i < 6; i++) {
+		snprintf(path, sizeof(path), "/proc/%s/ns/%s", ddocker_pid, namespaces[i]);
+		fds[i] = open(path, O_RDONLY);
+		if (fds[i] == -1) {
+			fprintf(stderr, "open %s failed: %s\n", path, strerror(errno));
+			exit(1);
+		}
+	}
+
+	// 不能setns到自己所在的user namespace，没有使用user namespace的容器跳过
+	struct stat self_ns, container_ns;
+	if (stat("/proc/self/ns/user", &self_ns) == -1 || fstat(fds[0], &container_ns) == -1) {
+		fprintf(stderr, "stat user namespace failed: %s\n", strerror(errno));
+		exit(1);
+	}
+	joined_userns = self_ns.st_ino != container_ns.st_ino || self_ns.st_dev != container_ns.st_dev;
+
+	for (i = 0; i < 6; i++) {
+		if (i == 0 && !joined_userns) {
+			close(fds[i]);
+			continue;
+		}
+		// 调用setns系统调用，进入对应的 namespace，任何一个失败都不能继续执行用户命令
+		if (setns(fds[i], 0) == -1) {
+			fprintf(stderr, "setns on %s namespace failed: %s\n", namespaces[i], strerror(errno));
+			exit(1);
+		}
+		close(fds[i]);
+	}
+
+	if (fchdir(rootfd) == -1 || chroot(".") == -1 || chdir("/") == -1) {
+		fprintf(stderr, "chroot to container root failed: %s\n", strerror(errno));
+		exit(1);
+	}
+	close(rootfd);
+
+	// 进入pid namespace之后只有子进程才会在新的namespace里，所以fork之后再exec用户命令
+	pid_t pid = fork();
+	if (pid == -1) {
+		fprintf(stderr, "fork failed: %s\n", strerror(errno));
+		exit(1);
+	}
+	if (pid == 0) {
+		// -it 时用户命令在容器的pid namespace里新建会话，并把pty设置成控制终端，
+		// 这样shell的作业控制看到的进程组在自己的namespace里
+		if (getenv("ddocker_exec_tty")) {
+			if (setsid() == -1 || ioctl(0, TIOCSCTTY, 0) == -1) {
+				fprintf(stderr, "set controlling tty failed: %s\n", strerror(errno));
+				exit(126);
+			}
+		}
+
+		char *workdir = getenv("ddocker_exec_workdir");
+		if (workdir && chdir(workdir) == -1) {
+			fprintf(stderr, "chdir to %s failed: %s\n", workdir, strerror(errno));
+			exit(126);
+		}
+		// 提高hard limit需要CAP_SYS_RESOURCE，在收缩bounding set和切换用户之前设置
+		char *rlimits = getenv("ddocker_exec_rlimits");
+		if (rlimits && set_rlimits(rlimits) == -1) {
+			fprintf(stderr, "set rlimits failed: %s\n", strerror(errno));
+			exit(126);
+		}
+		// 切换用户需要CAP_SETUID和CAP_SETGID，只收缩bounding set，不影响当前的effective集合
+		char *caps = getenv("ddocker_exec_caps");
+		if (caps && drop_capabilities(caps) == -1) {
+			fprintf(stderr, "drop capabilities failed: %s\n", strerror(errno));
+			exit(126);
+		}
+		if (getenv("ddocker_exec_no_new_privs") && prctl(PR_SET_NO_NEW_PRIVS, 1, 0, 0, 0) == -1) {
+			fprintf(stderr, "set no_new_privs failed: %s\n", strerror(errno));
+			exit(126);
+		}
+		// 没有设置no_new_privs时安装seccomp过滤器需要CAP_SYS_ADMIN，切换用户之后就没有了
+		char *seccomp = getenv("ddocker_exec_seccomp");
+		if (seccomp && install_seccomp(seccomp) == -1) {
+			fprintf(stderr, "install seccomp filter failed: %s\n", strerror(errno));
+			exit(126);
+		}
+		// 进入user namespace之后宿主机的root在容器内没有映射，默认切换成容器内的root
+		char *user = getenv("ddocker_exec_user");
+		if (!user && joined_userns) {
+			user = "0";
+		}
+		if (user && switch_user(user) == -1) {
+			fprintf(stderr, "switch to user %s failed: %s\n", user, strerror(errno));
+			exit(126);
+		}
+
+		unsetenv("ddocker_pid");
+		unsetenv("ddocker_exec_workdir");
+		unsetenv("ddocker_exec_user");
+		unsetenv("ddocker_exec_tty");
+		unsetenv("ddocker_exec_cgroups");
+		unsetenv("ddocker_exec_caps");
+		unsetenv("ddocker_exec_seccomp");
+		unsetenv("ddocker_exec_no_new_privs");
+		unsetenv("ddocker_exec_rlimits");
+		execvp(cmd[0], cmd);
+		fprintf(stderr, "exec %s failed: %s\n", cmd[0], strerror(errno));
+		exit(errno == ENOENT ? 127 : 126);
+	}
+
+	// 等待用户命令退出，把它的退出码作为自己的退出码
+	int status;
+	while (waitpid(pid, &status, 0) == -1) {
+		if (errno != EINTR) {
+			fprintf(stderr, "wait failed: %s\n", strerror(errno));
+			exit(1);
+		}
+	}
+	if (WIFSIGNALED(status)) {
+		exit(128 + WTERMSIG(status));
+	}
+	exit(WEXITSTATUS(status));
 }
 */
 import "C"
+
+// UserNSSupported cgo构建的构造函数在进程还是单线程时执行，可以进入容器的user namespace
+const UserNSSupported = true
+
+// Enter 在cgo构建中不会被调用，进入容器的工作已经在构造函数里完成并退出了进程
+func Enter() int {
+	return 1
+}
@@ -1,73 +1,255 @@
 package cmd
 
 import (
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"os"
 	"os/exec"
 	"strings"
+	"syscall"
 
 	"github.com/sirupsen/logrus"
 	"github.com/urfave/cli"
 
+	"github.com/devhg/ddocker/cgroups"
+	"github.com/devhg/ddocker/container"
 	// setns
-	_ "github.com/devhg/ddocker/cmd/enterns"
+	"github.com/devhg/ddocker/cmd/enterns"
 )
 
 const (
 	ENV_EXEC_PID = "ddocker_pid"
-	ENV_EXEC_CMD = "ddocker_cmd"
+	// 进入容器之后的工作目录和用户，由 enterns 在exec用户命令之前处理。
+	// 用户的格式是 uid:gid[:附加组,...]
+	ENV_EXEC_WORKDIR = "ddocker_exec_workdir"
+	ENV_EXEC_USER    = "ddocker_exec_user"
+	ENV_EXEC_TTY     = "ddocker_exec_tty"
+	// 容器cgroup的目录，多个目录用:分隔，enterns 在进入namespace之前把自己加入这些cgroup
+	ENV_EXEC_CGROUPS = "ddocker_exec_cgroups"
+	// 容器保留的capability位图，exec进去的进程和容器进程有同样的capability
+	ENV_EXEC_CAPS = "ddocker_exec_caps"
+	// 编码后的容器seccomp过滤器，enterns 在切换用户之前安装
+	ENV_EXEC_SECCOMP = "ddocker_exec_seccomp"
+	// 容器设置了no_new_privs时，exec进去的进程也要设置
+	ENV_EXEC_NO_NEW_PRIVS = "ddocker_exec_no_new_privs"
+	// 容器进程的资源限制，格式是 resource:soft:hard,...
+	ENV_EXEC_RLIMITS = "ddocker_exec_rlimits"
 )
 
 var ExecCommand = cli.Command{
 	Name:  "exec",
 	Usage: "exec a command into container",
+	// 容器ID之后的参数原样交给容器内的命令，例如 exec ${containerID} ls -d /tmp 中的 -d 不是exec的参数
+	SkipArgReorder: true,
+	Flags: []cli.Flag{
+		cli.BoolFlag{
+			Name:  "it",
+			Usage: "allocate a tty for the command",
+		},
+		cli.BoolFlag{
+			Name:  "d",
+			Usage: "run the command in the background",
+		},
+		cli.StringSliceFlag{
+			Name:  "e",
+			Usage: "set environment",
+		},
+		cli.StringFlag{
+			Name:  "w",
+			Usage: "working directory inside the container",
+		},
+		cli.StringFlag{
+			Name:  "u",
+			Usage: "run the command as name|uid[:group|gid], default is the user of the container",
+		},
+	},
 	Action: func(ctx *cli.Context) error {
 
+		// /proc/self/exe exec 重新执行时进入容器。cgo构建时构造函数已经完成并退出，
+		// 只有不使用cgo构建时才会执行到这里
 		if os.Getenv(ENV_EXEC_PID) != "" {
-			logrus.Infof("pid callback pid %d", os.Getgid())
+			if code := enterns.Enter(); code != 0 {
+				return cli.NewExitError("", code)
+			}
 			return nil
 		}
 
-		if len(ctx.Args()) < 2 {
-			return fmt.Errorf("expect shell command is: [ddocker exec containerID command]")
+		containerID, commandArr, opts, err := parseExecArgs(ctx)
+		if err != nil {
+			return err
 		}
 
-		containerID := ctx.Args().Get(0)
+		code, err := execConatiner(containerID, commandArr, opts)
+		if err != nil {
+			return err
+		}
+		if code != 0 {
+			// 以容器内命令的退出码退出
+			return cli.NewExitError("", code)
+		}
+		return nil
+	},
+}
 
-		var commandArr []string
-		commandArr = append(commandArr, ctx.Args().Tail()...)
+type execOptions struct {
+	tty     bool
+	detach  bool
+	env     []string
+	workdir string
+	user    string
+}
 
-		execConatiner(containerID, commandArr)
+// parseExecArgs 解析exec的参数，返回容器ID、容器内执行的命令和选项
+func parseExecArgs(ctx *cli.Context) (string, []string, *execOptions, error) {
+	if len(ctx.Args()) < 2 {
+		return "", nil, nil, fmt.Errorf("expect shell command is: [ddocker exec containerID command]")
+	}
 
-		return nil
-	},
+	opts := &execOptions{
+		tty:     ctx.Bool("it"),
+		detach:  ctx.Bool("d"),
+		env:     ctx.StringSlice("e"),
+		workdir: ctx.String("w"),
+		user:    ctx.String("u"),
+	}
+	if opts.tty && opts.detach {
+		return "", nil, nil, errors.New("-it and -d cannot be used together for exec")
+	}
+	for _, env := range opts.env {
+		if !strings.Contains(env, "=") {
+			return "", nil, nil, fmt.Errorf("invalid environment %q, expect KEY=VAL", env)
+		}
+	}
+
+	containerID := ctx.Args().Get(0)
+
+	var commandArr []string
+	commandArr = append(commandArr, ctx.Args().Tail()...)
+	return containerID, commandArr, opts, nil
 }
 
-func execConatiner(contianerID string, cmds []string) {
+// execConatiner 在容器内执行命令，返回命令的退出码
+func execConatiner(contianerID string, cmds []string, opts *execOptions) (int, error) {
 	// 根据容器id 获取进程 pid
-	cpid := GetContainerPID(contianerID)
-	if cpid == "" {
-		return
+	cinfo := GetContainerInfo(contianerID)
+	if cinfo == nil || cinfo.PID == "" {
+		return 0, fmt.Errorf("container %s not found", contianerID)
+	}
+	cpid := cinfo.PID
+	if cinfo.IDMapping != nil && !enterns.UserNSSupported {
+		return 0, fmt.Errorf("container %s runs in a user namespace (--userns-remap or rootless), "+
+			"exec into it requires ddocker built with cgo (CGO_ENABLED=1)", contianerID)
 	}
 
-	command := strings.Join(cmds, " ")
-	logrus.Infof("containerPID[%v] command[%v]", cpid, command)
+	logrus.Infof("containerPID[%v] command%q", cpid, cmds)
 
-	cmd := exec.Command("/proc/self/exe", "exec")
-	cmd.Stdin = os.Stdin
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
+	// 容器的资源限制对exec进去的进程同样生效
+	// rootless模式下容器可能没有cgroup
+	cgroupPaths, err := cgroups.NewCgroupManager(containerCgroupPath(contianerID)).Paths()
+	if err != nil && !container.Rootless() {
+		return 0, fmt.Errorf("get container %s cgroup error[%v]", contianerID, err)
+	}
 
-	os.Setenv(ENV_EXEC_PID, cpid)
-	os.Setenv(ENV_EXEC_CMD, command)
+	// 命令以参数列表的形式传给 enterns，由它在进入namespace之后直接execvp，不经过shell
+	cmd := exec.Command("/proc/self/exe", append([]string{"exec"}, cmds...)...)
+	cmd.SysProcAttr = &syscall.SysProcAttr{}
 
+	// 容器进程的环境变量，再加上 -e 指定的环境变量，后面的覆盖前面的
 	envs := getEnvByPID(cpid)
 	cmd.Env = append(os.Environ(), envs...)
+	cmd.Env = append(cmd.Env, opts.env...)
+	cmd.Env = append(cmd.Env, ENV_EXEC_PID+"="+cpid)
+	if cinfo.Capabilities != nil {
+		cmd.Env = append(cmd.Env, fmt.Sprintf("%s=%d", ENV_EXEC_CAPS, container.CapabilityMask(cinfo.Capabilities)))
+	}
+	if len(cinfo.Ulimits) > 0 {
+		cmd.Env = append(cmd.Env, ENV_EXEC_RLIMITS+"="+container.EncodeUlimits(cinfo.Ulimits))
+	}
+	if cinfo.NoNewPrivileges {
+		cmd.Env = append(cmd.Env, ENV_EXEC_NO_NEW_PRIVS+"=1")
+	}
+	if cinfo.Seccomp != nil {
+		filter, err := cinfo.Seccomp.BuildFilter(cinfo.Capabilities)
+		if err != nil {
+			return 0, fmt.Errorf("build seccomp filter error[%v]", err)
+		}
+		cmd.Env = append(cmd.Env, ENV_EXEC_SECCOMP+"="+container.EncodeSeccompFilter(filter))
+	}
+	if len(cgroupPaths) > 0 {
+		cmd.Env = append(cmd.Env, ENV_EXEC_CGROUPS+"="+strings.Join(cgroupPaths, ":"))
+	}
+	// 没有指定 -w 时和容器进程在同一个工作目录
+	workdir := opts.workdir
+	if workdir == "" {
+		workdir = cinfo.Workdir
+	}
+	if workdir != "" {
+		cmd.Env = append(cmd.Env, ENV_EXEC_WORKDIR+"="+workdir)
+	}
+	// 没有指定 -u 时和容器进程使用同一个用户，用户名在容器的 /etc/passwd 中解析成数字
+	user := opts.user
+	if user == "" {
+		user = cinfo.User
+	}
+	if user != "" {
+		ids, err := container.LookupExecUser(cpid, user)
+		if err != nil {
+			return 0, fmt.Errorf("lookup user %s error[%v]", user, err)
+		}
+		cmd.Env = append(cmd.Env, ENV_EXEC_USER+"="+ids)
+	}
+
+	switch {
+	case opts.detach:
+		// 后台运行的命令不继承当前终端，当前进程启动之后直接返回
+		cmd.SysProcAttr.Setsid = true
+		if err := cmd.Start(); err != nil {
+			return 0, fmt.Errorf("exec container[%v] error[%v]", contianerID, err)
+		}
+		_ = cmd.Process.Release()
+		return 0, nil
+
+	case opts.tty:
+		console, err := container.NewConsole()
+		if err != nil {
+			return 0, fmt.Errorf("allocate tty error[%v]", err)
+		}
+		cmd.Stdin = console.Slave
+		cmd.Stdout = console.Slave
+		cmd.Stderr = console.Slave
+		// 控制终端由 enterns 在容器内的子进程上设置
+		cmd.SysProcAttr.Setsid = true
+		cmd.Env = append(cmd.Env, ENV_EXEC_TTY+"=1")
+
+		if err := cmd.Start(); err != nil {
+			console.Slave.Close()
+			console.Master.Close()
+			return 0, fmt.Errorf("exec container[%v] error[%v]", contianerID, err)
+		}
+		console.Slave.Close()
 
-	if err := cmd.Run(); err != nil {
-		logrus.Errorf("exec container[%v] error[%v]", contianerID, err)
+		done := console.AttachTerminal(os.Stdin, os.Stdout)
+		err = cmd.Wait()
+		done()
+		return exitCode(contianerID, err)
+
+	default:
+		cmd.Stdin = os.Stdin
+		cmd.Stdout = os.Stdout
+		cmd.Stderr = os.Stderr
+		return exitCode(contianerID, cmd.Run())
+	}
+}
+
+func exitCode(contianerID string, err error) (int, error) {
+	if err == nil {
+		return 0, nil
+	}
+	if exitErr, ok := err.(*exec.ExitError); ok {
+		return exitErr.ExitCode(), nil
 	}
+	return 0, fmt.Errorf("exec container[%v] error[%v]", contianerID, err)
 }
 
 func getEnvByPID(pid string) []string {
@@ -0,0 +1,98 @@
+package cmd
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/urfave/cli"
+)
+
+// runExecArgs 用exec命令的参数定义解析args，返回解析结果
+func runExecArgs(t *testing.T, args ...string) (string, []string, *execOptions, error) {
+	var (
+		containerID string
+		cmds        []string
+		opts        *execOptions
+		parseErr    error
+	)
+	command := ExecCommand
+	command.Action = func(ctx *cli.Context) error {
+		containerID, cmds, opts, parseErr = parseExecArgs(ctx)
+		return nil
+	}
+
+	app := cli.NewApp()
+	app.Commands = []cli.Command{command}
+	if err := app.Run(append([]string{"ddocker", "exec"}, args...)); err != nil {
+		t.Fatalf("run exec %v error: %v", args, err)
+	}
+	return containerID, cmds, opts, parseErr
+}
+
+func TestParseExecArgs(t *testing.T) {
+	tests := []struct {
+		args    []string
+		id      string
+		cmds    []string
+		detach  bool
+		tty     bool
+		user    string
+		workdir string
+		env     []string
+	}{
+		{
+			args: []string{"1234567890", "ls", "-d", "/tmp"},
+			id:   "1234567890",
+			cmds: []string{"ls", "-d", "/tmp"},
+		},
+		{
+			args: []string{"1234567890", "ps", "-u", "root"},
+			id:   "1234567890",
+			cmds: []string{"ps", "-u", "root"},
+		},
+		{
+			args:    []string{"-d", "-u", "1000:1000", "-w", "/srv", "-e", "A=1", "1234567890", "sh", "-c", "env -i -e"},
+			id:      "1234567890",
+			cmds:    []string{"sh", "-c", "env -i -e"},
+			detach:  true,
+			user:    "1000:1000",
+			workdir: "/srv",
+			env:     []string{"A=1"},
+		},
+		{
+			// 用户名在exec时到容器的 /etc/passwd 中解析
+			args: []string{"-u", "nobody:nogroup", "1234567890", "id"},
+			id:   "1234567890",
+			cmds: []string{"id"},
+			user: "nobody:nogroup",
+		},
+		{
+			args: []string{"-it", "1234567890", "sh", "-it"},
+			id:   "1234567890",
+			cmds: []string{"sh", "-it"},
+			tty:  true,
+		},
+	}
+	for _, tt := range tests {
+		id, cmds, opts, err := runExecArgs(t, tt.args...)
+		if err != nil {
+			t.Errorf("exec %v error: %v", tt.args, err)
+			continue
+		}
+		if id != tt.id || !reflect.DeepEqual(cmds, tt.cmds) {
+			t.Errorf("exec %v = %q %q, want %q %q", tt.args, id, cmds, tt.id, tt.cmds)
+		}
+		if opts.detach != tt.detach || opts.tty != tt.tty || opts.user != tt.user ||
+			opts.workdir != tt.workdir || len(opts.env) != len(tt.env) ||
+			(len(tt.env) > 0 && !reflect.DeepEqual(opts.env, tt.env)) {
+			t.Errorf("exec %v options = %+v", tt.args, opts)
+		}
+	}
+
+	if _, _, _, err := runExecArgs(t, "-it", "-d", "1234567890", "sh"); err == nil {
+		t.Error("exec -it -d should fail")
+	}
+	if _, _, _, err := runExecArgs(t, "1234567890"); err == nil {
+		t.Error("exec without command should fail")
+	}
+}
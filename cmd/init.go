@@ -1,9 +1,6 @@
 package cmd
 
 import (
-	"os"
-	"strings"
-
 	"github.com/sirupsen/logrus"
 	"github.com/urfave/cli"
 
@@ -27,10 +24,3 @@ var InitCommand = cli.Command{
 		return err
 	},
 }
-
-func sendInitCommand(commands []string, writePipe *os.File) {
-	command := strings.Join(commands, " ")
-	logrus.Infof("command all is %s", command)
-	_, _ = writePipe.WriteString(command)
-	writePipe.Close()
-}
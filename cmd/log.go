@@ -4,34 +4,133 @@ import (
 	"fmt"
 	"os"
 	"path"
+	"strconv"
+	"time"
 
 	"github.com/sirupsen/logrus"
 	"github.com/urfave/cli"
 
 	"github.com/devhg/ddocker/container"
+	"github.com/devhg/ddocker/logger"
 )
 
 var LogCommand = cli.Command{
 	Name:  "log",
 	Usage: "print logs of a container",
+	Flags: []cli.Flag{
+		cli.BoolFlag{
+			Name:  "stdout",
+			Usage: "only print the stdout stream",
+		},
+		cli.BoolFlag{
+			Name:  "stderr",
+			Usage: "only print the stderr stream",
+		},
+		cli.BoolFlag{
+			Name:  "follow, f",
+			Usage: "follow log output until the container exits",
+		},
+		cli.StringFlag{
+			Name:  "tail",
+			Usage: "number of lines to show from the end of the logs",
+			Value: "all",
+		},
+		cli.StringFlag{
+			Name:  "since",
+			Usage: "show logs since timestamp (e.g. 2021-08-07T16:01:34Z) or relative (e.g. 10m)",
+		},
+		cli.StringFlag{
+			Name:  "until",
+			Usage: "show logs before timestamp (e.g. 2021-08-07T16:01:34Z) or relative (e.g. 10m)",
+		},
+		cli.BoolFlag{
+			Name:  "timestamps, t",
+			Usage: "show timestamps",
+		},
+	},
 	Action: func(ctx *cli.Context) error {
 		if len(ctx.Args()) < 1 {
 			return fmt.Errorf("please input your container id :)")
 		}
-
 		containerID := ctx.Args().Get(0)
-		logContainer(containerID)
+
+		opts := &logOptions{
+			// 两个都不指定时输出所有的stream
+			streams: map[string]bool{
+				logger.Stdout: ctx.Bool("stdout") || !ctx.Bool("stderr"),
+				logger.Stderr: ctx.Bool("stderr") || !ctx.Bool("stdout"),
+			},
+			timestamps: ctx.Bool("timestamps"),
+			read: &logger.ReadConfig{
+				Tail:   -1,
+				Follow: ctx.Bool("follow"),
+				Stopped: func() bool {
+					return !containerRunning(containerID)
+				},
+			},
+		}
+
+		if tail := ctx.String("tail"); tail != "all" {
+			n, err := strconv.Atoi(tail)
+			if err != nil || n < 0 {
+				return fmt.Errorf("invalid tail %q, expect a non-negative number or all", tail)
+			}
+			opts.read.Tail = n
+		}
+
+		var err error
+		if since := ctx.String("since"); since != "" {
+			if opts.read.Since, err = parseTimeFilter(since); err != nil {
+				return err
+			}
+		}
+		if until := ctx.String("until"); until != "" {
+			if opts.read.Until, err = parseTimeFilter(until); err != nil {
+				return err
+			}
+		}
+
+		logContainer(containerID, opts)
 		return nil
 	},
 }
 
-func logContainer(contianerID string) {
-	stdLogFile := path.Join(container.DefaultInfoLocation, contianerID, container.StdLogFileName)
-	bytes, err := os.ReadFile(stdLogFile)
-	if err != nil {
-		logrus.Errorf("container log open file %v error", err)
+type logOptions struct {
+	streams    map[string]bool
+	timestamps bool
+	read       *logger.ReadConfig
+}
+
+// logContainer 读取容器json-file格式的日志，stdout和stderr的记录分别输出到当前的stdout和stderr
+func logContainer(contianerID string, opts *logOptions) {
+	// 只有json-file驱动会在本地留下日志文件
+	cinfo := GetContainerInfo(contianerID)
+	if cinfo == nil {
+		return
+	}
+	if cinfo.LogDriver != "" && cinfo.LogDriver != logger.JSONFileDriver {
+		logrus.Errorf("container %s uses log driver %s, log only works with %s", contianerID, cinfo.LogDriver, logger.JSONFileDriver)
 		return
 	}
 
-	fmt.Fprint(os.Stdout, string(bytes))
+	stdLogFile := path.Join(container.DefaultInfoLocation, contianerID, container.StdLogFileName)
+
+	err := logger.ReadLog(stdLogFile, opts.read, func(e *logger.Entry) error {
+		if !opts.streams[e.Stream] {
+			return nil
+		}
+
+		out := os.Stdout
+		if e.Stream == logger.Stderr {
+			out = os.Stderr
+		}
+		if opts.timestamps {
+			fmt.Fprint(out, e.Time.Format(time.RFC3339Nano), " ")
+		}
+		_, err := fmt.Fprint(out, e.Log)
+		return err
+	})
+	if err != nil {
+		logrus.Errorf("read container log error[%v]", err)
+	}
 }
@@ -15,6 +15,7 @@ var NetworkCommand = cli.Command{
 		createCommand,
 		listCommand,
 		removeCommand,
+		networkPruneCommand,
 	},
 }
 
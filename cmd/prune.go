@@ -0,0 +1,344 @@
+package cmd
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/urfave/cli"
+
+	"github.com/devhg/ddocker/container"
+	"github.com/devhg/ddocker/network"
+	"github.com/devhg/ddocker/util"
+)
+
+var ContainerCommand = cli.Command{
+	Name:  "container",
+	Usage: "container management commands",
+	Subcommands: []cli.Command{
+		{
+			Name:  "prune",
+			Usage: "remove all stopped containers and leftover container layers",
+			Flags: pruneFlags,
+			Action: func(ctx *cli.Context) error {
+				return runPrune(ctx, pruneContainers)
+			},
+		},
+	},
+}
+
+var ImageCommand = cli.Command{
+	Name:  "image",
+	Usage: "image management commands",
+	Subcommands: []cli.Command{
+		{
+			Name:  "prune",
+			Usage: "remove extracted image layers not used by any container",
+			Flags: pruneFlags,
+			Action: func(ctx *cli.Context) error {
+				return runPrune(ctx, pruneImages)
+			},
+		},
+	},
+}
+
+var SystemCommand = cli.Command{
+	Name:  "system",
+	Usage: "system management commands",
+	Subcommands: []cli.Command{
+		{
+			Name:  "prune",
+			Usage: "remove stopped containers, dangling images and unused networks",
+			Flags: pruneFlags,
+			Action: func(ctx *cli.Context) error {
+				// 先删除容器，容器占用的镜像和网络才能被清理
+				return runPrune(ctx, pruneContainers, pruneImages, pruneNetworks)
+			},
+		},
+	},
+}
+
+var networkPruneCommand = cli.Command{
+	Name:  "prune",
+	Usage: "remove all networks not used by any container",
+	Flags: pruneFlags,
+	Action: func(ctx *cli.Context) error {
+		return runPrune(ctx, pruneNetworks)
+	},
+}
+
+var pruneFlags = []cli.Flag{
+	cli.StringSliceFlag{
+		Name:  "filter",
+		Usage: "provide filter values (e.g. 'until=24h')",
+	},
+	cli.BoolFlag{
+		Name:  "dry-run",
+		Usage: "only show what would be removed and the space it would reclaim",
+	},
+}
+
+type pruneOptions struct {
+	// 只清理until之前创建的资源，零值表示不限制
+	until  time.Time
+	dryRun bool
+}
+
+// createdBefore 判断创建时间为t的资源是否满足until过滤条件
+func (o *pruneOptions) createdBefore(t time.Time) bool {
+	return o.until.IsZero() || t.Before(o.until)
+}
+
+type pruneReport struct {
+	kind      string
+	items     []string
+	reclaimed int64
+}
+
+type pruneFunc func(opts *pruneOptions) (*pruneReport, error)
+
+func runPrune(ctx *cli.Context, prunes ...pruneFunc) error {
+	opts, err := parsePruneOptions(ctx)
+	if err != nil {
+		return err
+	}
+
+	var total int64
+	for _, prune := range prunes {
+		report, err := prune(opts)
+		if err != nil {
+			return err
+		}
+
+		if opts.dryRun {
+			fmt.Printf("Would remove %s:\n", report.kind)
+		} else {
+			fmt.Printf("Deleted %s:\n", report.kind)
+		}
+		for _, item := range report.items {
+			fmt.Println(item)
+		}
+		fmt.Println()
+		total += report.reclaimed
+	}
+
+	if opts.dryRun {
+		fmt.Printf("Total space that would be reclaimed: %s\n", util.HumanSize(total))
+	} else {
+		fmt.Printf("Total reclaimed space: %s\n", util.HumanSize(total))
+	}
+	return nil
+}
+
+// parsePruneOptions 解析 --filter until=24h 和 --dry-run
+func parsePruneOptions(ctx *cli.Context) (*pruneOptions, error) {
+	opts := &pruneOptions{dryRun: ctx.Bool("dry-run")}
+
+	for _, filter := range ctx.StringSlice("filter") {
+		kv := strings.SplitN(filter, "=", 2)
+		if len(kv) != 2 || kv[0] != "until" {
+			return nil, fmt.Errorf("invalid filter %q, only until=<duration|timestamp> is supported", filter)
+		}
+
+		t, err := parseTimeFilter(kv[1])
+		if err != nil {
+			return nil, err
+		}
+		opts.until = t
+	}
+	return opts, nil
+}
+
+// orphanGracePeriod 没有容器信息的目录至少存在这么久才当作残留清理
+const orphanGracePeriod = time.Minute
+
+// pruneContainers 删除已经停止的容器，以及没有对应容器信息的读写层和挂载点
+func pruneContainers(opts *pruneOptions) (*pruneReport, error) {
+	report := &pruneReport{kind: "containers"}
+
+	known := make(map[string]bool)
+	for _, cinfo := range listContainerInfos() {
+		known[cinfo.ID] = true
+
+		created, err := time.ParseInLocation("2006-01-02 15:04:05", cinfo.CreatedTime, time.Local)
+		if err != nil {
+			logrus.Warnf("parse container[%v] create time error[%v]", cinfo.ID, err)
+			continue
+		}
+		if cinfo.Status != container.StatusStopped || !opts.createdBefore(created) {
+			continue
+		}
+
+		size := util.DirSize(fmt.Sprintf(container.LayoutOf(cinfo.IDMapping).WriteLayer, cinfo.ID)) +
+			util.DirSize(path.Join(container.DefaultInfoLocation, cinfo.ID))
+		if !opts.dryRun {
+			if err := removeContainer(cinfo.ID, false); err != nil {
+				logrus.Errorf("remove container[%v] error[%v]", cinfo.ID, err)
+				continue
+			}
+		}
+		report.items = append(report.items, cinfo.ID)
+		report.reclaimed += size
+	}
+
+	orphans := orphanContainerDirs(known)
+	for id, modTime := range orphans {
+		// run先创建读写层和挂载点，之后才记录容器信息，刚创建的目录可能属于正在启动的容器
+		if time.Since(modTime) < orphanGracePeriod || !opts.createdBefore(modTime) {
+			continue
+		}
+
+		var size int64
+		for _, layout := range container.WorkspaceLayouts() {
+			size += util.DirSize(fmt.Sprintf(layout.WriteLayer, id))
+		}
+		if !opts.dryRun {
+			container.DeleteWorkSpace(id, "")
+		}
+		report.items = append(report.items, id)
+		report.reclaimed += size
+	}
+	return report, nil
+}
+
+// orphanContainerDirs 返回每种目录布局下 writeLayer/*、mnt/* 和 work/* 中残留的、
+// 已经没有容器信息的容器ID，以及目录的修改时间。数据卷的workdir是 ${containerID}-volume
+func orphanContainerDirs(known map[string]bool) map[string]time.Time {
+	orphans := make(map[string]time.Time)
+	for _, layout := range container.WorkspaceLayouts() {
+		for _, tmpl := range []string{layout.WriteLayer, layout.Mnt, layout.Work} {
+			dir := path.Dir(fmt.Sprintf(tmpl, "x"))
+			files, err := ioutil.ReadDir(dir)
+			if err != nil {
+				continue
+			}
+			for _, f := range files {
+				id := strings.TrimSuffix(f.Name(), "-volume")
+				if !f.IsDir() || known[id] {
+					continue
+				}
+				if t, ok := orphans[id]; !ok || f.ModTime().After(t) {
+					orphans[id] = f.ModTime()
+				}
+			}
+		}
+	}
+	return orphans
+}
+
+// pruneImages 删除没有被任何容器使用的、从镜像tar包解压出来的只读层目录。
+// 只清理 RootURL 下 ${image}.tar 对应的镜像层和每个id映射目录下它的属主平移过的副本，需要时可以重新解压
+func pruneImages(opts *pruneOptions) (*pruneReport, error) {
+	report := &pruneReport{kind: "images"}
+
+	images, err := imageNames()
+	if err != nil {
+		return nil, err
+	}
+
+	inUse := imagesInUse()
+	for _, image := range images {
+		for _, layer := range imageLayers(image) {
+			f, err := os.Lstat(layer)
+			if err != nil || !f.IsDir() || inUse[layer] || !opts.createdBefore(f.ModTime()) {
+				continue
+			}
+
+			size := util.DirSize(layer)
+			if !opts.dryRun {
+				if err := os.RemoveAll(layer); err != nil {
+					logrus.Errorf("remove image dir %v error[%v]", layer, err)
+					continue
+				}
+			}
+			report.items = append(report.items, layer)
+			report.reclaimed += size
+		}
+	}
+	return report, nil
+}
+
+// imageNames 返回 RootURL 下所有镜像tar包对应的镜像名
+func imageNames() ([]string, error) {
+	files, err := ioutil.ReadDir(container.RootURL)
+	if err != nil {
+		return nil, err
+	}
+
+	var images []string
+	for _, f := range files {
+		if f.Mode().IsRegular() && strings.HasSuffix(f.Name(), ".tar") {
+			images = append(images, strings.TrimSuffix(f.Name(), ".tar"))
+		}
+	}
+	return images, nil
+}
+
+// imageLayers 返回镜像解压出来的只读层目录，以及每个id映射目录下 userns-remap 使用的副本
+func imageLayers(image string) []string {
+	layers := []string{path.Join(container.RootURL, image)}
+	for _, root := range container.RemapRoots() {
+		layers = append(layers, path.Join(root, image))
+	}
+	return layers
+}
+
+// imagesInUse 返回正在被容器使用的镜像只读层目录，包括容器信息中记录的镜像，
+// 以及当前overlay挂载中作为lowerdir的目录
+func imagesInUse() map[string]bool {
+	inUse := make(map[string]bool)
+	for _, cinfo := range listContainerInfos() {
+		if cinfo.Image != "" {
+			inUse[container.ImageLayer(cinfo.Image, cinfo.IDMapping)] = true
+		}
+	}
+
+	// overlay /root/mnt/xxx overlay rw,relatime,lowerdir=/root/busybox,upperdir=...,workdir=... 0 0
+	f, err := os.Open("/proc/self/mounts")
+	if err != nil {
+		logrus.Warnln(err)
+		return inUse
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 4 || fields[2] != "overlay" {
+			continue
+		}
+		for _, opt := range strings.Split(fields[3], ",") {
+			if strings.HasPrefix(opt, "lowerdir=") {
+				for _, dir := range strings.Split(strings.TrimPrefix(opt, "lowerdir="), ":") {
+					inUse[path.Clean(dir)] = true
+				}
+			}
+		}
+	}
+	return inUse
+}
+
+// pruneNetworks 删除没有任何容器连接的网络
+func pruneNetworks(opts *pruneOptions) (*pruneReport, error) {
+	if err := network.Init(); err != nil {
+		return nil, err
+	}
+
+	inUse := make(map[string]bool)
+	for _, cinfo := range listContainerInfos() {
+		if cinfo.Network != "" {
+			inUse[cinfo.Network] = true
+		}
+	}
+
+	names, reclaimed, err := network.PruneNetworks(inUse, opts.until, opts.dryRun)
+	if err != nil {
+		return nil, err
+	}
+	return &pruneReport{kind: "networks", items: names, reclaimed: reclaimed}, nil
+}
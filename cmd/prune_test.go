@@ -0,0 +1,158 @@
+package cmd
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/devhg/ddocker/container"
+)
+
+// setupPruneRoot 把镜像、容器目录和容器信息目录都指向临时目录
+func setupPruneRoot(t *testing.T) (string, func()) {
+	dir, err := ioutil.TempDir("", "ddocker-prune")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	oldRoot, oldMnt, oldWrite, oldWork, oldInfo, oldRemap := container.RootURL, container.MntURL,
+		container.WriteLayerURL, container.WorkDirURL, container.DefaultInfoLocation, container.RemapRootURL
+	container.RootURL = dir + "/"
+	container.RemapRootURL = filepath.Join(dir, "remap") + "/"
+	container.MntURL = filepath.Join(dir, "mnt") + "/%s"
+	container.WriteLayerURL = filepath.Join(dir, "writeLayer") + "/%s"
+	container.WorkDirURL = filepath.Join(dir, "work") + "/%s"
+	container.DefaultInfoLocation = filepath.Join(dir, "info") + "/"
+
+	return dir, func() {
+		container.RootURL, container.MntURL, container.WriteLayerURL,
+			container.WorkDirURL, container.DefaultInfoLocation, container.RemapRootURL = oldRoot, oldMnt, oldWrite, oldWork, oldInfo, oldRemap
+		os.RemoveAll(dir)
+	}
+}
+
+func mkdirs(t *testing.T, root string, dirs ...string) {
+	for _, d := range dirs {
+		if err := os.MkdirAll(path.Join(root, d), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func touch(t *testing.T, root string, files ...string) {
+	for _, f := range files {
+		if err := ioutil.WriteFile(path.Join(root, f), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestPruneImages(t *testing.T) {
+	dir, cleanup := setupPruneRoot(t)
+	defer cleanup()
+
+	touch(t, dir, "busybox.tar", "foo.tar", "foo_1.2.tar", "notes.tar.gz")
+	mkdirs(t, dir,
+		"busybox/bin",
+		"busybox_backup",
+		"foo",
+		"foo_1.2",
+		"notes",
+		"other",
+		"mnt/1234567890",
+		"writeLayer/1234567890",
+		"work/1234567890",
+		"remap/100000.100000/busybox/bin",
+		"remap/100000.100000/other",
+		"remap/backup/busybox",
+	)
+
+	report, err := pruneImages(&pruneOptions{dryRun: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var want []string
+	for _, name := range []string{"busybox", "remap/100000.100000/busybox", "foo", "foo_1.2"} {
+		want = append(want, path.Join(dir, name))
+	}
+	if !reflect.DeepEqual(report.items, want) {
+		t.Errorf("dry run items = %v, want %v", report.items, want)
+	}
+	if _, err := os.Stat(path.Join(dir, "busybox")); err != nil {
+		t.Errorf("dry run removed busybox: %v", err)
+	}
+
+	if _, err := pruneImages(&pruneOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"busybox", "remap/100000.100000/busybox", "foo", "foo_1.2"} {
+		if _, err := os.Stat(path.Join(dir, name)); !os.IsNotExist(err) {
+			t.Errorf("%s not removed", name)
+		}
+	}
+	for _, name := range []string{"busybox.tar", "busybox_backup", "notes", "other", "mnt/1234567890",
+		"writeLayer/1234567890", "remap/100000.100000/other", "remap/backup/busybox"} {
+		if _, err := os.Stat(path.Join(dir, name)); err != nil {
+			t.Errorf("%s should be kept: %v", name, err)
+		}
+	}
+}
+
+func TestPruneOrphanContainerDirs(t *testing.T) {
+	dir, cleanup := setupPruneRoot(t)
+	defer cleanup()
+
+	mkdirs(t, dir,
+		"info/1111111111",
+		"writeLayer/1111111111",
+		"work/1111111111",
+		"writeLayer/2222222222",
+		"work/2222222222",
+		"work/2222222222-volume",
+		"work/3333333333",
+		"mnt/4444444444",
+		"remap/100000.100000/writeLayer/5555555555",
+		"remap/100000.100000/work/5555555555",
+	)
+	// 残留目录要超过宽限期才清理
+	old := time.Now().Add(-2 * orphanGracePeriod)
+	filepath.Walk(dir, func(p string, _ os.FileInfo, _ error) error {
+		return os.Chtimes(p, old, old)
+	})
+	// 正在启动的容器还没有记录容器信息
+	mkdirs(t, dir, "writeLayer/6666666666", "mnt/6666666666")
+	// 有容器信息的容器不是残留
+	ioutil.WriteFile(path.Join(dir, "info/1111111111", container.ConfigName),
+		[]byte(`{"id":"1111111111","status":"running","create_time":"2006-01-02 15:04:05"}`), 0644)
+
+	report, err := pruneContainers(&pruneOptions{dryRun: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(report.items)
+	want := []string{"2222222222", "3333333333", "4444444444", "5555555555"}
+	if !reflect.DeepEqual(report.items, want) {
+		t.Errorf("orphans = %v, want %v", report.items, want)
+	}
+
+	os.RemoveAll(path.Join(dir, "mnt"))
+	if _, err := pruneContainers(&pruneOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"writeLayer/2222222222", "work/2222222222", "work/2222222222-volume", "work/3333333333",
+		"remap/100000.100000/writeLayer/5555555555", "remap/100000.100000/work/5555555555"} {
+		if _, err := os.Stat(path.Join(dir, name)); !os.IsNotExist(err) {
+			t.Errorf("%s not removed", name)
+		}
+	}
+	for _, name := range []string{"writeLayer/1111111111", "work/1111111111", "writeLayer/6666666666"} {
+		if _, err := os.Stat(path.Join(dir, name)); err != nil {
+			t.Errorf("%s should be kept: %v", name, err)
+		}
+	}
+}
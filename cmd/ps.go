@@ -2,8 +2,8 @@ package cmd
 
 import (
 	"fmt"
-	"io/ioutil"
 	"os"
+	"strings"
 	"text/tabwriter"
 
 	"github.com/sirupsen/logrus"
@@ -15,33 +15,107 @@ import (
 var PsCommand = cli.Command{
 	Name:  "ps",
 	Usage: "list all the container",
+	Flags: []cli.Flag{
+		cli.StringSliceFlag{
+			Name:  "filter, f",
+			Usage: "filter output based on conditions: label=<key>[=<value>], status=running|stopped|exit, name=<name>",
+		},
+	},
 	Action: func(ctx *cli.Context) error {
-		ListContainers()
+		filters, err := parsePsFilters(ctx.StringSlice("filter"))
+		if err != nil {
+			return err
+		}
+		listContainers(filters)
 		return nil
 	},
 }
 
-func ListContainers() {
-	dir := container.DefaultInfoLocation
-	files, err := ioutil.ReadDir(dir)
-	if err != nil {
-		logrus.Errorf("read dir %s error[%v]", dir, err)
+// psFilters ps --filter 的过滤条件。label 指定多次时要全部满足，status 和 name 指定多次时
+// 满足其中一个即可，不同种条件需要同时满足，和docker一致
+type psFilters struct {
+	labels   []string
+	statuses []string
+	names    []string
+}
+
+// parsePsFilters 解析 --filter label=k[=v]、status=running 和 name=web
+func parsePsFilters(filters []string) (*psFilters, error) {
+	f := &psFilters{}
+	for _, filter := range filters {
+		kv := strings.SplitN(filter, "=", 2)
+		if len(kv) != 2 || kv[1] == "" {
+			return nil, fmt.Errorf("invalid filter %q, expect key=value", filter)
+		}
+
+		switch kv[0] {
+		case "label":
+			f.labels = append(f.labels, kv[1])
+		case "status":
+			switch kv[1] {
+			case container.StatusRunning, container.StatusStopped, container.StatusExit:
+			default:
+				return nil, fmt.Errorf("invalid filter %q, status must be one of %s, %s, %s",
+					filter, container.StatusRunning, container.StatusStopped, container.StatusExit)
+			}
+			f.statuses = append(f.statuses, kv[1])
+		case "name":
+			f.names = append(f.names, kv[1])
+		default:
+			return nil, fmt.Errorf("invalid filter %q, only label, status and name are supported", filter)
+		}
 	}
+	return f, nil
+}
 
-	var infos []*container.ContainerInfo
-	for _, file := range files {
-		info, err := readContainerInfo(file)
-		if err != nil {
-			logrus.Errorf("get container info error[%v]", err)
-			continue
+// match 判断容器是否满足过滤条件。label=k 只要求有这个标签，label=k=v 还要求值相同，
+// name 按子串匹配，和docker一致
+func (f *psFilters) match(info *container.ContainerInfo) bool {
+	return matchAll(f.labels, func(label string) bool {
+		kv := strings.SplitN(label, "=", 2)
+		v, ok := info.Labels[kv[0]]
+		return ok && (len(kv) == 1 || v == kv[1])
+	}) && matchAny(f.statuses, func(status string) bool {
+		return info.Status == status
+	}) && matchAny(f.names, func(name string) bool {
+		return strings.Contains(info.Name, name)
+	})
+}
+
+// matchAll 要求每个条件都满足，没有指定条件时不过滤
+func matchAll(values []string, fn func(string) bool) bool {
+	for _, v := range values {
+		if !fn(v) {
+			return false
 		}
-		infos = append(infos, info)
 	}
+	return true
+}
+
+// matchAny 没有指定条件时不过滤
+func matchAny(values []string, fn func(string) bool) bool {
+	if len(values) == 0 {
+		return true
+	}
+	for _, v := range values {
+		if fn(v) {
+			return true
+		}
+	}
+	return false
+}
+
+// listContainers 打印满足过滤条件的容器
+func listContainers(filters *psFilters) {
+	infos := listContainerInfos()
 
 	// 控制台打印对齐的表格
 	w := tabwriter.NewWriter(os.Stdout, 12, 1, 3, ' ', 0)
 	fmt.Fprint(w, "ID\tPID\tNAME\tSTATUS\tCOMMAND\tCREATE\n")
 	for _, info := range infos {
+		if !filters.match(info) {
+			continue
+		}
 		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n",
 			info.ID,
 			info.PID,
@@ -0,0 +1,51 @@
+package cmd
+
+import (
+	"testing"
+
+	"github.com/devhg/ddocker/container"
+)
+
+func TestPsFilters(t *testing.T) {
+	web := &container.ContainerInfo{Name: "web-1", Status: container.StatusRunning,
+		Labels: map[string]string{"app": "web", "env": "prod"}}
+	db := &container.ContainerInfo{Name: "db", Status: container.StatusStopped,
+		Labels: map[string]string{"app": "db"}}
+
+	tests := []struct {
+		filters []string
+		web     bool
+		db      bool
+	}{
+		{nil, true, true},
+		{[]string{"label=app"}, true, true},
+		{[]string{"label=app=web"}, true, false},
+		// 多个label要同时满足
+		{[]string{"label=app", "label=env"}, true, false},
+		{[]string{"label=app=db", "label=env=prod"}, false, false},
+		// 多个status、name满足其中一个即可
+		{[]string{"status=running", "status=stopped"}, true, true},
+		{[]string{"name=web", "name=db"}, true, true},
+		{[]string{"name=web", "status=stopped"}, false, false},
+		{[]string{"label=app", "status=stopped"}, false, true},
+	}
+	for _, tt := range tests {
+		f, err := parsePsFilters(tt.filters)
+		if err != nil {
+			t.Errorf("parsePsFilters(%q) error: %v", tt.filters, err)
+			continue
+		}
+		if got := f.match(web); got != tt.web {
+			t.Errorf("%q match web = %v, want %v", tt.filters, got, tt.web)
+		}
+		if got := f.match(db); got != tt.db {
+			t.Errorf("%q match db = %v, want %v", tt.filters, got, tt.db)
+		}
+	}
+
+	for _, filter := range []string{"label", "label=", "status=paused", "id=123"} {
+		if _, err := parsePsFilters([]string{filter}); err == nil {
+			t.Errorf("parsePsFilters(%q) expected error", filter)
+		}
+	}
+}
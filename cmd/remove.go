@@ -4,33 +4,58 @@ import (
 	"errors"
 	"fmt"
 
-	"github.com/devhg/ddocker/container"
 	"github.com/urfave/cli"
+
+	"github.com/devhg/ddocker/cgroups"
+	"github.com/devhg/ddocker/container"
 )
 
 var RemoveCommand = cli.Command{
 	Name:  "rm",
 	Usage: "remove a container",
+	Flags: []cli.Flag{
+		cli.BoolFlag{
+			Name:  "force, f",
+			Usage: "stop and remove a running container",
+		},
+	},
 	Action: func(ctx *cli.Context) error {
 		if len(ctx.Args()) < 1 {
 			return errors.New("missing containerID")
 		}
 
 		containerID := ctx.Args().Get(0)
-		return removeContainer(containerID)
+		return removeContainer(containerID, ctx.Bool("force"))
 	},
 }
 
-func removeContainer(containerID string) error {
+// removeContainer 删除容器，并释放容器占用的所有资源，
+// 运行中的容器需要指定force，先停止容器再删除
+func removeContainer(containerID string, force bool) error {
 	cinfo := GetContainerInfo(containerID)
 	if cinfo == nil {
 		return fmt.Errorf("container[%v] not found", containerID)
 	}
 
 	if cinfo.Status != container.StatusStopped {
-		return fmt.Errorf("canot remove a %v container", cinfo.Status)
+		if !force {
+			return fmt.Errorf("canot remove a %v container, stop it first or use rm -f", cinfo.Status)
+		}
+
+		// 只结束容器进程，资源在下面统一清理一次
+		if err := killContainer(cinfo); err != nil {
+			return err
+		}
+		// 指定了--rm的容器由监控进程在容器退出后清理，这里再清理一次会重复释放IP，
+		// 释放的地址可能已经分配给了别的容器
+		if cinfo.AutoRemove {
+			return nil
+		}
+		// 监控进程在容器退出后会改写容器信息，等它写完再删除信息目录
+		waitContainerStopped(containerID, stopTimeout)
 	}
 
-	removeContainerInfo(containerID)
+	cleanupContainer(cinfo)
+	cgroups.NewCgroupManager(containerCgroupPath(containerID)).Destroy()
 	return nil
 }
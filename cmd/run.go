@@ -3,7 +3,14 @@ package cmd
 import (
 	"errors"
 	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
 	"strconv"
+	"strings"
+	"syscall"
 
 	"github.com/sirupsen/logrus"
 	"github.com/urfave/cli"
@@ -11,6 +18,7 @@ import (
 	"github.com/devhg/ddocker/cgroups"
 	"github.com/devhg/ddocker/cgroups/subsystems"
 	"github.com/devhg/ddocker/container"
+	"github.com/devhg/ddocker/logger"
 	"github.com/devhg/ddocker/network"
 	"github.com/devhg/ddocker/util"
 )
@@ -55,6 +63,18 @@ var RunCommand = cli.Command{
 			Name:  "e",
 			Usage: "set environment",
 		},
+		cli.StringSliceFlag{
+			Name:  "env-file",
+			Usage: "read environment variables from a file of KEY=VAL lines, -e takes precedence",
+		},
+		cli.StringSliceFlag{
+			Name:  "label",
+			Usage: "set metadata on the container, key=value",
+		},
+		cli.StringSliceFlag{
+			Name:  "label-file",
+			Usage: "read labels from a file of key=value lines",
+		},
 		cli.StringFlag{
 			Name:  "net",
 			Usage: "container network",
@@ -63,6 +83,87 @@ var RunCommand = cli.Command{
 			Name:  "p",
 			Usage: "port mapping",
 		},
+		cli.BoolFlag{
+			Name:  "rm",
+			Usage: "automatically remove the container when it exits",
+		},
+		cli.StringFlag{
+			Name:  "userns-remap",
+			Usage: "run the container in a user namespace, mapping ids from /etc/subuid and /etc/subgid of the user (default: ddocker), an existing -v host directory must be writable by the remapped root",
+		},
+		cli.StringSliceFlag{
+			Name:  "cap-add",
+			Usage: "add linux capabilities, e.g. NET_ADMIN or ALL",
+		},
+		cli.StringSliceFlag{
+			Name:  "cap-drop",
+			Usage: "drop linux capabilities, e.g. NET_RAW or ALL",
+		},
+		cli.BoolFlag{
+			Name:  "privileged",
+			Usage: "give all capabilities to the container and disable seccomp",
+		},
+		cli.StringSliceFlag{
+			Name:  "security-opt",
+			Usage: "security options: seccomp=<profile.json>, seccomp=unconfined or no-new-privileges",
+		},
+		cli.StringSliceFlag{
+			Name:  "device",
+			Usage: "add a host device to the container, host[:container[:permissions]], e.g. /dev/sdc:/dev/xvdc:rwm",
+		},
+		cli.StringFlag{
+			Name:  "w, workdir",
+			Usage: "working directory inside the container, created if missing",
+		},
+		cli.StringFlag{
+			Name:  "u, user",
+			Usage: "run the command as name|uid[:group|gid]",
+		},
+		cli.StringFlag{
+			Name:  "entrypoint",
+			Usage: "overwrite the command to run, the arguments after the image are passed to it",
+		},
+		cli.StringFlag{
+			Name:  "hostname",
+			Usage: "container host name, defaults to the short container ID",
+		},
+		cli.StringSliceFlag{
+			Name:  "dns",
+			Usage: "set custom DNS servers",
+		},
+		cli.StringSliceFlag{
+			Name:  "dns-search",
+			Usage: "set custom DNS search domains",
+		},
+		cli.StringSliceFlag{
+			Name:  "add-host",
+			Usage: "add a custom host-to-IP mapping (host:ip)",
+		},
+		cli.StringSliceFlag{
+			Name:  "ulimit",
+			Usage: "set a resource limit, name=soft[:hard], e.g. nofile=65536:65536",
+		},
+		cli.StringFlag{
+			Name:  "shm-size",
+			Usage: "size of /dev/shm, e.g. 64m, 1g",
+		},
+		cli.StringSliceFlag{
+			Name:  "tmpfs",
+			Usage: "mount a tmpfs directory, path[:options], replaces the default mount on the same path",
+		},
+		cli.BoolFlag{
+			Name:  "read-only",
+			Usage: "mount the container's root filesystem as read only",
+		},
+		cli.StringFlag{
+			Name:  "log-driver",
+			Value: logger.JSONFileDriver,
+			Usage: "log driver: json-file, syslog or none",
+		},
+		cli.StringSliceFlag{
+			Name:  "log-opt",
+			Usage: "log driver options, e.g. max-size=10m, max-file=3, syslog-address=udp://127.0.0.1:514, tag=web",
+		},
 	},
 	/*
 		1. 判断参数是否包含command
@@ -80,101 +181,562 @@ var RunCommand = cli.Command{
 			commands = append(commands, arg)
 		}
 
+		// -it 和 -d 同时指定时，容器的终端由后台的监控进程持有，之后可以通过attach连接
 		tty := ctx.Bool("it")
 		detach := ctx.Bool("d")
-		if tty && detach {
-			return errors.New("-it and -d parameter can not both provider")
+
+		logConfig, err := logger.ParseConfig(ctx.String("log-driver"), ctx.StringSlice("log-opt"))
+		if err != nil {
+			return err
+		}
+
+		var idmap *container.IDMapping
+		if remapUser := ctx.String("userns-remap"); remapUser != "" {
+			if idmap, err = container.NewIDMapping(remapUser); err != nil {
+				return err
+			}
+			if !container.Rootless() {
+				if _, err := container.PrepareRemapRoot(idmap); err != nil {
+					return err
+				}
+			}
+		}
+
+		caps, err := container.TweakCapabilities(ctx.Bool("privileged"), ctx.StringSlice("cap-add"), ctx.StringSlice("cap-drop"))
+		if err != nil {
+			return err
+		}
+
+		security, err := parseSecurityOpts(ctx.StringSlice("security-opt"), ctx.Bool("privileged"))
+		if err != nil {
+			return err
+		}
+		// 在启动容器之前检查seccomp配置能否编译，init进程中的错误只能在日志里看到
+		if security.seccomp != nil {
+			if _, err := security.seccomp.BuildFilter(caps); err != nil {
+				return err
+			}
+		}
+
+		devices := append([]*container.Device{}, container.DefaultDevices...)
+		for _, spec := range ctx.StringSlice("device") {
+			device, err := container.ParseDevice(spec)
+			if err != nil {
+				return err
+			}
+			devices = append(devices, device)
+		}
+
+		mounts, err := parseMounts(ctx.String("shm-size"), ctx.StringSlice("tmpfs"), ctx.Bool("privileged"))
+		if err != nil {
+			return err
+		}
+
+		args := commands[1:]
+		if entrypoint := ctx.String("entrypoint"); entrypoint != "" {
+			args = append([]string{entrypoint}, args...)
+		}
+		if len(args) == 0 {
+			return errors.New("missing container command")
+		}
+		if workdir := ctx.String("workdir"); workdir != "" && !filepath.IsAbs(workdir) {
+			return fmt.Errorf("invalid workdir %q, must be an absolute path", workdir)
+		}
+
+		if len(ctx.String("hostname")) > 64 {
+			return fmt.Errorf("invalid hostname %q, longer than 64 characters", ctx.String("hostname"))
+		}
+		for _, dns := range ctx.StringSlice("dns") {
+			if net.ParseIP(dns) == nil {
+				return fmt.Errorf("invalid --dns %q, expect an IP address", dns)
+			}
+		}
+		for _, host := range ctx.StringSlice("add-host") {
+			if _, _, err := container.ParseExtraHost(host); err != nil {
+				return err
+			}
+		}
+
+		ulimits, err := parseUlimits(ctx.StringSlice("ulimit"))
+		if err != nil {
+			return err
+		}
+
+		env, err := parseEnv(ctx.StringSlice("env-file"), ctx.StringSlice("e"))
+		if err != nil {
+			return err
+		}
+		labels, err := container.ParseLabels(ctx.StringSlice("label"), ctx.StringSlice("label-file"))
+		if err != nil {
+			return err
 		}
 
 		resConf := &subsystems.ResourceConfig{
 			MemoryLimit: ctx.String("mm"),
 			CPUSet:      ctx.String("cpuset"),
 			CPUShare:    ctx.String("cpushare"),
+			Devices:     container.CgroupDeviceRules(devices, ctx.Bool("privileged")),
 		}
 
-		containerName := ctx.String("name")
-		volumes := ctx.String("v")
-		imageName := commands[0]
-		commands = commands[1:]
-
-		logrus.Infof("create tty[%v] name[%v]", tty, containerName)
+		opts := &runOptions{
+			tty:         tty,
+			detach:      detach,
+			autoRemove:  ctx.Bool("rm"),
+			commands:    args,
+			res:         resConf,
+			name:        ctx.String("name"),
+			volume:      ctx.String("v"), // volume 临时放在这里
+			image:       commands[0],
+			env:         env,
+			netName:     ctx.String("net"),
+			portMapping: ctx.StringSlice("p"),
+			logConfig:   logConfig,
+			idmap:       idmap,
+			caps:        caps,
+			security:    security,
+			readonly:    ctx.Bool("read-only"),
+			devices:     devices,
+			mounts:      mounts,
+			hostname:    ctx.String("hostname"),
+			dns:         ctx.StringSlice("dns"),
+			dnsSearch:   ctx.StringSlice("dns-search"),
+			extraHosts:  ctx.StringSlice("add-host"),
+			workdir:     ctx.String("workdir"),
+			user:        ctx.String("user"),
+			ulimits:     ulimits,
+			labels:      labels,
+		}
 
-		env := ctx.StringSlice("e")
-		logrus.Infof("create env [%v]", env)
+		if container.Rootless() {
+			if err := checkRootlessOptions(opts); err != nil {
+				return err
+			}
+			opts.idmap = container.RootlessIDMapping()
+		}
 
-		network := ctx.String("net")
-		logrus.Infof("create net [%v]", network)
+		logrus.Infof("create tty[%v] name[%v]", opts.tty, opts.name)
+		logrus.Infof("create env [%v]", opts.env)
+		logrus.Infof("create net [%v]", opts.netName)
+		logrus.Infof("create portMapping [%v]", opts.portMapping)
 
-		portMapping := ctx.StringSlice("p")
-		logrus.Infof("create portMapping [%v]", portMapping)
+		// 分离式容器由后台的监控进程负责创建和等待容器退出，当前进程启动监控进程后直接返回
+		if detach && os.Getenv(ENV_RUN_MONITOR) == "" {
+			return startMonitor()
+		}
+		_ = os.Unsetenv(ENV_RUN_MONITOR)
 
-		run(tty, commands, resConf, containerName, volumes, imageName, env, network, portMapping) // volume 临时放在这里
+		run(opts)
 		return nil
 	},
 }
 
+// ENV_RUN_MONITOR 标记当前进程是分离式容器的监控进程
+const ENV_RUN_MONITOR = "ddocker_monitor"
+
+// runOptions 是run命令解析出来的容器配置
+type runOptions struct {
+	tty         bool
+	detach      bool
+	autoRemove  bool
+	commands    []string
+	res         *subsystems.ResourceConfig
+	name        string
+	volume      string
+	image       string
+	env         []string
+	netName     string
+	portMapping []string
+	logConfig   *logger.Config
+	idmap       *container.IDMapping
+	caps        []string
+	security    *securityOptions
+	readonly    bool
+	devices     []*container.Device
+	mounts      []container.Mount
+	hostname    string
+	dns         []string
+	dnsSearch   []string
+	extraHosts  []string
+	workdir     string
+	user        string
+	ulimits     []*container.Ulimit
+	labels      map[string]string
+}
+
+// writeEtcFiles 在容器状态目录中生成容器的 /etc/hosts、/etc/hostname 和 /etc/resolv.conf。
+// slirp4netns的容器地址是固定的，DNS由slirp4netns转发给宿主机，宿主机上只监听本地地址的resolver也能使用
+func writeEtcFiles(opts *runOptions, cinfo *container.ContainerInfo) error {
+	ip, dns := cinfo.IPAddress, opts.dns
+	if opts.netName == network.SlirpNetwork {
+		ip = network.SlirpIP
+		if len(dns) == 0 {
+			dns = []string{network.SlirpDNS}
+		}
+	}
+
+	if err := container.WriteHostnameFile(cinfo.ID, opts.hostname); err != nil {
+		return err
+	}
+	if err := container.WriteHostsFile(cinfo.ID, opts.hostname, ip, opts.extraHosts); err != nil {
+		return err
+	}
+	return container.WriteResolvConf(cinfo.ID, dns, opts.dnsSearch)
+}
+
+// parseUlimits 解析 --ulimit，和全局默认配置中的限制合并
+func parseUlimits(specs []string) ([]*container.Ulimit, error) {
+	config, err := container.LoadDaemonConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	var ulimits []*container.Ulimit
+	for _, spec := range specs {
+		u, err := container.ParseUlimit(spec)
+		if err != nil {
+			return nil, err
+		}
+		ulimits = append(ulimits, u)
+	}
+	return container.MergeUlimits(config.DefaultUlimits, ulimits)
+}
+
+// parseEnv 读取 --env-file 中的环境变量，-e 指定的放在后面，同名时覆盖文件中的值
+func parseEnv(envFiles, envs []string) ([]string, error) {
+	var result []string
+	for _, file := range envFiles {
+		fileEnvs, err := container.ParseEnvFile(file)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, fileEnvs...)
+	}
+	return append(result, envs...), nil
+}
+
+// parseMounts 返回容器内默认的挂载，--tmpfs 挂载到同一个路径时替换默认的挂载
+func parseMounts(shmSize string, tmpfs []string, privileged bool) ([]container.Mount, error) {
+	size := container.DefaultShmSize
+	if shmSize != "" {
+		var err error
+		if size, err = util.ParseSize(shmSize); err != nil || size <= 0 {
+			return nil, fmt.Errorf("invalid --shm-size %q", shmSize)
+		}
+	}
+
+	var mounts []container.Mount
+	for _, spec := range tmpfs {
+		m, err := container.ParseTmpfs(spec)
+		if err != nil {
+			return nil, err
+		}
+		mounts = append(mounts, m)
+	}
+	return container.MergeMounts(container.DefaultMounts(size, privileged), mounts), nil
+}
+
+// securityOptions 是 --security-opt 和 --privileged 决定的安全配置
+type securityOptions struct {
+	opts            []string
+	seccomp         *container.Seccomp
+	noNewPrivileges bool
+	maskedPaths     []string
+	readonlyPaths   []string
+}
+
+// parseSecurityOpts 解析 --security-opt，没有指定seccomp时使用内置的默认配置，
+// 和docker一样 --privileged 的容器不启用seccomp，也不屏蔽 /proc 下的路径
+func parseSecurityOpts(secOpts []string, privileged bool) (*securityOptions, error) {
+	security := &securityOptions{opts: secOpts}
+	if !privileged {
+		security.seccomp = container.DefaultSeccompProfile()
+		security.maskedPaths = container.DefaultMaskedPaths
+		security.readonlyPaths = container.DefaultReadonlyPaths
+	}
+
+	for _, opt := range secOpts {
+		// no-new-privileges 可以不带值，等价于 no-new-privileges=true
+		if opt == "no-new-privileges" {
+			security.noNewPrivileges = true
+			continue
+		}
+		kv := strings.SplitN(opt, "=", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("invalid --security-opt %q", opt)
+		}
+
+		switch kv[0] {
+		case "no-new-privileges":
+			v, err := strconv.ParseBool(kv[1])
+			if err != nil {
+				return nil, fmt.Errorf("invalid --security-opt %q", opt)
+			}
+			security.noNewPrivileges = v
+		case "seccomp":
+			if kv[1] == "unconfined" {
+				security.seccomp = nil
+				continue
+			}
+			profile, err := container.LoadSeccompProfile(kv[1])
+			if err != nil {
+				return nil, err
+			}
+			security.seccomp = profile
+		default:
+			return nil, fmt.Errorf("invalid --security-opt %q", opt)
+		}
+	}
+	return security, nil
+}
+
+// checkRootlessOptions 普通用户不能创建bridge、iptables规则，也不能使用/etc/subuid中的id范围
+func checkRootlessOptions(opts *runOptions) error {
+	if opts.idmap != nil {
+		return errors.New("--userns-remap is not supported in rootless mode, container root is always mapped to the current user")
+	}
+	switch opts.netName {
+	case "", network.NoneNetwork, network.SlirpNetwork:
+	default:
+		return fmt.Errorf("rootless mode only supports --net %s or %s", network.NoneNetwork, network.SlirpNetwork)
+	}
+	if len(opts.portMapping) > 0 {
+		return errors.New("port mapping is not supported in rootless mode")
+	}
+	return nil
+}
+
+// startMonitor 以相同的参数重新执行自己，作为分离式容器的监控进程。
+// 监控进程脱离当前终端的会话，负责启动容器、等待容器退出并做退出后的清理，
+// 容器创建成功后通过管道(fd 3)把容器ID传回来。
+func startMonitor() error {
+	readPipe, writePipe, err := container.NewPipe()
+	if err != nil {
+		return err
+	}
+	defer readPipe.Close()
+
+	cmd := exec.Command("/proc/self/exe", os.Args[1:]...)
+	cmd.Env = append(os.Environ(), ENV_RUN_MONITOR+"=1")
+	cmd.ExtraFiles = []*os.File{writePipe}
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+	if err := cmd.Start(); err != nil {
+		writePipe.Close()
+		return fmt.Errorf("start container monitor error: %v", err)
+	}
+	writePipe.Close()
+
+	msg, err := ioutil.ReadAll(readPipe)
+	if err != nil || len(msg) == 0 {
+		return fmt.Errorf("container monitor exited before container started")
+	}
+	if strings.HasPrefix(string(msg), monitorErrorPrefix) {
+		_ = cmd.Wait()
+		return errors.New(strings.TrimPrefix(string(msg), monitorErrorPrefix))
+	}
+	fmt.Println(string(msg))
+
+	return cmd.Process.Release()
+}
+
+// monitorErrorPrefix 监控进程启动容器失败时写回给run进程的内容以它开头，后面是错误信息
+const monitorErrorPrefix = "error: "
+
+// notifyMonitorStarter 监控进程在容器启动成功后把容器ID写回给启动它的run进程，
+// 失败时写回错误信息。只能调用一次，写完之后关闭管道
+func notifyMonitorStarter(containerID string, startErr error) {
+	pipe := os.NewFile(uintptr(3), "monitor")
+	defer pipe.Close()
+
+	msg := containerID
+	if startErr != nil {
+		msg = monitorErrorPrefix + startErr.Error()
+	}
+	if _, err := pipe.WriteString(msg); err != nil {
+		logrus.Errorf("notify container monitor starter error: %v", err)
+	}
+}
+
 // run 这里是真正开始之前创建好的command调用，它首先会clone出来一个namespace隔离的
 // 进程，然后在子进程中调用/proc/self/exe，也就是自己调用自己，发送init参数，
 // 调用之前写的init方法，去初始化一些容器的参数，
-func run(tty bool, commands []string, res *subsystems.ResourceConfig, name, volume, image string, env []string,
-	netName string, portMapping []string) {
+//
+// 交互式容器由当前进程等待退出，分离式容器由监控进程等待退出。容器退出后，
+// 指定了--rm的容器会清理掉所有资源，否则只记录为stopped状态。
+func run(opts *runOptions) {
+	if opts.detach {
+		// 通知管道不能泄漏给容器进程和其他子进程
+		syscall.CloseOnExec(3)
+	}
+
 	// 首先生成长度为10的容器id
 	id := util.RandStringBytes(10)
 
-	parentProcess, writePipe := container.NewParentProcess(tty, id, volume, image, env)
+	// 启动失败时分离式容器把错误返回给run命令，否则run命令只能看到监控进程退出
+	startFailed := func(err error) {
+		logrus.Error(err)
+		if opts.detach {
+			notifyMonitorStarter("", err)
+		}
+	}
+
+	if opts.hostname == "" {
+		opts.hostname = container.DefaultHostname(id)
+	}
+
+	initConfig := &container.InitConfig{
+		Args:            opts.commands,
+		Hostname:        opts.hostname,
+		Workdir:         opts.workdir,
+		User:            opts.user,
+		Ulimits:         opts.ulimits,
+		Capabilities:    opts.caps,
+		Seccomp:         opts.security.seccomp,
+		NoNewPrivileges: opts.security.noNewPrivileges,
+		ReadonlyRootfs:  opts.readonly,
+		MaskedPaths:     opts.security.maskedPaths,
+		ReadonlyPaths:   opts.security.readonlyPaths,
+		Mounts:          opts.mounts,
+		Devices:         opts.devices,
+	}
+	parentProcess, writePipe, cio := container.NewParentProcess(opts.tty, id, opts.volume, opts.image, opts.env, opts.idmap, initConfig)
 	if parentProcess == nil {
-		logrus.Errorf("new parent process error")
+		startFailed(errors.New("new parent process error"))
 		return
 	}
 	if err := parentProcess.Start(); err != nil {
-		logrus.Error(err)
+		startFailed(fmt.Errorf("start container process error: %v", err))
+		return
 	}
+	cio.CloseChildFiles()
 
 	// 记录容器信息
-	containerID, err := container.RecordContainerInfo(parentProcess.Process.Pid, commands, id, name, volume)
-	if err != nil {
-		logrus.Errorf("func[RecordContainerInfo] for %s error: %v", name, err)
-		return
+	cinfo := &container.ContainerInfo{
+		ID:              id,
+		PID:             strconv.Itoa(parentProcess.Process.Pid),
+		Name:            opts.name,
+		Command:         strings.Join(opts.commands, " "),
+		Volume:          opts.volume,
+		Image:           opts.image,
+		PortMapping:     opts.portMapping,
+		Network:         opts.netName,
+		AutoRemove:      opts.autoRemove,
+		TTY:             opts.tty,
+		LogDriver:       opts.logConfig.Driver,
+		IDMapping:       opts.idmap,
+		Capabilities:    opts.caps,
+		SecurityOpt:     opts.security.opts,
+		Seccomp:         opts.security.seccomp,
+		NoNewPrivileges: opts.security.noNewPrivileges,
+		ReadonlyRootfs:  opts.readonly,
+		Hostname:        opts.hostname,
+		DNS:             opts.dns,
+		DNSSearch:       opts.dnsSearch,
+		ExtraHosts:      opts.extraHosts,
+		Workdir:         opts.workdir,
+		User:            opts.user,
+		Ulimits:         opts.ulimits,
+		Labels:          opts.labels,
 	}
 
-	// 创建cgroupManager，并调用 Set 设置资源限制 和 Apply 在限制上生效
-	cgroupManager := cgroups.NewCgroupManager("ddocker-cgroup")
-	defer cgroupManager.Destroy()
+	// 容器进程已经启动，之后出错时要杀掉容器进程并清理掉容器占用的资源
+	setupFailed := func(err error) {
+		_ = parentProcess.Process.Kill()
+		_ = parentProcess.Wait()
+		cleanupContainer(cinfo)
+		startFailed(err)
+	}
 
-	// 设置资源限制
-	err = cgroupManager.Set(res)
-	if err != nil {
-		panic(err)
+	if err := container.RecordContainerInfo(cinfo); err != nil {
+		setupFailed(fmt.Errorf("record container info error: %v", err))
+		return
 	}
 
-	// 将容器进程加入到各个subsystem挂载对应的cgroup中
-	_ = cgroupManager.Apply(parentProcess.Process.Pid)
-	if err != nil {
-		panic(err)
+	// 创建cgroupManager，并调用 Set 设置资源限制 和 Apply 在限制上生效。
+	// rootless模式下没有委派给当前用户的cgroup时不做资源限制
+	cgroupManager := cgroups.NewCgroupManager(containerCgroupPath(id))
+	if container.Rootless() && !cgroupManager.Writable() {
+		logrus.Warnf("no writable cgroup for user %d, resource limits are ignored", os.Geteuid())
+	} else {
+		defer cgroupManager.Destroy()
+
+		// 设置资源限制
+		if err := cgroupManager.Set(opts.res); err != nil {
+			setupFailed(fmt.Errorf("set cgroup resource limits error: %v", err))
+			return
+		}
+
+		// 将容器进程加入到各个subsystem挂载对应的cgroup中
+		if err := cgroupManager.Apply(parentProcess.Process.Pid); err != nil {
+			setupFailed(fmt.Errorf("apply cgroup error: %v", err))
+			return
+		}
 	}
 
-	if netName != "" {
+	switch opts.netName {
+	case "", network.NoneNetwork:
+	case network.SlirpNetwork:
+		slirp, err := network.StartSlirp(parentProcess.Process.Pid, opts.idmap != nil)
+		if err != nil {
+			setupFailed(fmt.Errorf("start slirp network error: %v", err))
+			return
+		}
+		defer func() {
+			_ = slirp.Process.Kill()
+			_ = slirp.Wait()
+		}()
+	default:
 		// config container network
 		if err := network.Init(); err != nil {
-			panic(err)
-		}
-		cinfo := &container.ContainerInfo{
-			ID:          containerID,
-			PID:         strconv.Itoa(parentProcess.Process.Pid),
-			Name:        name,
-			PortMapping: portMapping,
+			setupFailed(fmt.Errorf("init network error: %v", err))
+			return
 		}
-		if err := network.Connect(netName, cinfo); err != nil {
-			logrus.Errorf("error Connect Network %v", err)
+		if err := network.Connect(opts.netName, cinfo); err != nil {
+			setupFailed(fmt.Errorf("connect network %s error: %v", opts.netName, err))
 			return
 		}
+		if err := writeContainerInfo(id, cinfo); err != nil {
+			logrus.Errorf("rewrite container info error[%v]", err)
+		}
+	}
+
+	// 网络配置好之后才知道容器的IP，这时生成 /etc/hosts 等文件，由init进程bind挂载
+	if err := writeEtcFiles(opts, cinfo); err != nil {
+		setupFailed(fmt.Errorf("write etc files error: %v", err))
+		return
+	}
+	initConfig.Mounts = append(initConfig.Mounts, container.EtcMounts(id)...)
+
+	// 交互式容器直接连接当前终端，其他容器的输入输出由当前进程(监控进程)
+	// 转发到日志和attach的客户端
+	var done func()
+	var err error
+	if opts.tty && !opts.detach {
+		done = cio.Console.AttachTerminal(os.Stdin, os.Stdout)
+	} else if done, err = container.ServeContainerIO(id, cio, opts.logConfig); err != nil {
+		logrus.Errorf("serve container io error: %v", err)
+		done = func() {}
 	}
 
 	// 初始化容器
-	sendInitCommand(commands, writePipe)
-	if tty {
+	if err := container.SendInitConfig(writePipe, initConfig); err != nil {
+		// 先结束容器进程再调用done，done会等待容器的输出转发完，-it 时还要恢复终端
+		_ = parentProcess.Process.Kill()
 		_ = parentProcess.Wait()
-		container.DeleteContainerInfo(containerID)
-		container.DeleteWorkSpace(containerID, volume)
+		done()
+		setupFailed(fmt.Errorf("send init config error: %v", err))
+		return
+	}
+
+	// cgroup和网络都配置好之后，才告诉run命令容器已经启动
+	if opts.detach {
+		notifyMonitorStarter(id, nil)
+	}
+	_ = parentProcess.Wait()
+	done()
+
+	if opts.autoRemove {
+		cleanupContainer(cinfo)
+		return
 	}
+	markContainerStopped(id)
 }
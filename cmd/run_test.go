@@ -0,0 +1,32 @@
+package cmd
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestParseMountsShmSize(t *testing.T) {
+	mounts, err := parseMounts("1.5m", nil, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	found := false
+	for _, m := range mounts {
+		if m.Target == "/dev/shm" {
+			found = true
+			if want := fmt.Sprintf("mode=1777,size=%d", 1536<<10); m.Data != want {
+				t.Errorf("shm data = %q, want %q", m.Data, want)
+			}
+		}
+	}
+	if !found {
+		t.Error("no /dev/shm mount")
+	}
+
+	// util.ParseSize 接受0，/dev/shm 的大小必须是正数
+	for _, size := range []string{"0", "0k", "12x", "-1m"} {
+		if _, err := parseMounts(size, nil, false); err == nil {
+			t.Errorf("parseMounts(%q) expected error", size)
+		}
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"strconv"
 	"syscall"
+	"time"
 
 	"github.com/sirupsen/logrus"
 	"github.com/urfave/cli"
@@ -25,13 +26,43 @@ var StopCommand = cli.Command{
 	},
 }
 
+// stopTimeout 发送SIGTERM之后等待容器退出的时间，超时后发送SIGKILL
+const stopTimeout = 10 * time.Second
+
 func stopContainer(containerID string) error {
 	cinfo := GetContainerInfo(containerID)
+	if cinfo == nil {
+		return fmt.Errorf("container[%v] not found", containerID)
+	}
+
+	if err := killContainer(cinfo); err != nil {
+		return err
+	}
 
+	// 指定了--rm的容器，由监控进程在容器退出后清理
+	if cinfo.AutoRemove {
+		return nil
+	}
+	container.DeleteWorkSpace(containerID, cinfo.Volume)
+
+	// 修改容器状态
+	cinfo.Status = container.StatusStopped
+	cinfo.PID = ""
+
+	if err := writeContainerInfo(containerID, cinfo); err != nil {
+		logrus.Errorf("rewrite container info error[%v]", err)
+		return err
+	}
+
+	return nil
+}
+
+// killContainer 结束容器进程并等待它退出，容器的资源由调用者清理
+func killContainer(cinfo *container.ContainerInfo) error {
 	// 根据容器id 获取进程 pid
 	cpid := cinfo.PID
 	if cpid == "" {
-		return fmt.Errorf("canot find containerID[%v]'s PID", containerID)
+		return fmt.Errorf("canot find containerID[%v]'s PID", cinfo.ID)
 	}
 
 	pid, err := strconv.Atoi(cpid)
@@ -39,22 +70,44 @@ func stopContainer(containerID string) error {
 		return err
 	}
 
-	container.DeleteWorkSpace(containerID, cinfo.Volume)
-
 	// 系统调用kill发送信号给容器进程，通过传递syscall.SIGTERM信号，杀掉容器主进程
 	if err := syscall.Kill(pid, syscall.SIGTERM); err != nil {
-		logrus.Errorf("stop container[%v] error[%v]", containerID, err)
+		logrus.Errorf("stop container[%v] error[%v]", cinfo.ID, err)
 		return err
 	}
 
-	// 修改容器状态
-	cinfo.Status = container.StatusStopped
-	cinfo.PID = ""
+	// 容器进程是pid namespace中的1号进程，没有注册SIGTERM处理函数时会忽略这个信号，
+	// 等待超时后用SIGKILL强制结束
+	if !waitProcessExit(pid, stopTimeout) {
+		logrus.Warnf("container[%v] did not exit in %v, killing it", cinfo.ID, stopTimeout)
+		if err := syscall.Kill(pid, syscall.SIGKILL); err != nil && err != syscall.ESRCH {
+			return err
+		}
+		waitProcessExit(pid, stopTimeout)
+	}
+	return nil
+}
 
-	if err := writeContainerInfo(containerID, cinfo); err != nil {
-		logrus.Errorf("rewrite container info error[%v]", err)
-		return err
+// waitContainerStopped 等待监控进程把容器状态记录为stopped，监控进程已经不在时超时返回
+func waitContainerStopped(containerID string, timeout time.Duration) {
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		cinfo := GetContainerInfo(containerID)
+		if cinfo == nil || cinfo.Status == container.StatusStopped {
+			return
+		}
+		time.Sleep(100 * time.Millisecond)
 	}
+}
 
-	return nil
+// waitProcessExit 等待进程退出，超时返回false
+func waitProcessExit(pid int, timeout time.Duration) bool {
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		if err := syscall.Kill(pid, 0); err == syscall.ESRCH {
+			return true
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	return false
 }
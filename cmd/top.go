@@ -0,0 +1,271 @@
+package cmd
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/urfave/cli"
+
+	"github.com/devhg/ddocker/cgroups"
+)
+
+var TopCommand = cli.Command{
+	Name:  "top",
+	Usage: "display the running processes of a container",
+	Action: func(ctx *cli.Context) error {
+		if len(ctx.Args()) < 1 {
+			return errors.New("missing containerID")
+		}
+
+		containerID := ctx.Args().Get(0)
+		return topContainer(containerID)
+	},
+}
+
+// 内核统计CPU时间的单位，/proc/${pid}/stat 中的时间都是这个单位，Linux上固定是100
+const clockTicks = 100
+
+// procInfo 从 /proc/${pid}/stat 和 /proc/${pid}/status 读取的进程信息
+type procInfo struct {
+	pid   int
+	ppid  int
+	cpid  string // 容器内的pid，来自status的NSpid
+	uid   string
+	state string
+	tty   string
+	start time.Time
+	cpu   time.Duration
+	cmd   string
+}
+
+func topContainer(containerID string) error {
+	if !containerRunning(containerID) {
+		return fmt.Errorf("container[%v] is not running", containerID)
+	}
+
+	pids, err := containerPids(containerID)
+	if err != nil {
+		return err
+	}
+
+	bootTime, err := readBootTime()
+	if err != nil {
+		return err
+	}
+
+	// 容器的pid namespace，exec时留在宿主机上等待用户命令的进程也在容器的cgroup中，但不在这个namespace里
+	pidNS, err := os.Readlink(fmt.Sprintf("/proc/%s/ns/pid", GetContainerPID(containerID)))
+	if err != nil {
+		return err
+	}
+
+	// 控制台打印对齐的表格
+	w := tabwriter.NewWriter(os.Stdout, 8, 1, 3, ' ', 0)
+	fmt.Fprint(w, "UID\tPID\tPPID\tCPID\tSTAT\tSTIME\tTTY\tTIME\tCMD\n")
+	for _, pid := range pids {
+		p, err := readProcInfo(pid, bootTime)
+		if err != nil {
+			// 进程在读取的过程中退出了
+			if os.IsNotExist(err) {
+				continue
+			}
+			return err
+		}
+		if ns, err := os.Readlink(fmt.Sprintf("/proc/%d/ns/pid", pid)); err != nil || ns != pidNS {
+			p.cpid = "-"
+		}
+
+		fmt.Fprintf(w, "%s\t%d\t%d\t%s\t%s\t%s\t%s\t%s\t%s\n",
+			p.uid,
+			p.pid,
+			p.ppid,
+			p.cpid,
+			p.state,
+			p.start.Format("15:04"),
+			p.tty,
+			formatCPUTime(p.cpu),
+			p.cmd,
+		)
+	}
+
+	// 刷新输出流缓冲区
+	if err := w.Flush(); err != nil {
+		logrus.Errorf("tabwriter flush error[%v]", err)
+	}
+	return nil
+}
+
+// containerPids 读取容器cgroup中的所有进程，包括exec进入容器的进程
+func containerPids(containerID string) ([]int, error) {
+	paths, err := cgroups.NewCgroupManager(containerCgroupPath(containerID)).Paths()
+	if err != nil {
+		return nil, err
+	}
+	if len(paths) == 0 {
+		return nil, errors.New("no cgroup subsystem found")
+	}
+
+	// 每个subsystem中的进程都是一样的，读第一个就可以了
+	b, err := ioutil.ReadFile(path.Join(paths[0], "cgroup.procs"))
+	if err != nil {
+		return nil, err
+	}
+
+	var pids []int
+	for _, line := range strings.Fields(string(b)) {
+		pid, err := strconv.Atoi(line)
+		if err != nil {
+			return nil, fmt.Errorf("invalid pid %q in cgroup.procs", line)
+		}
+		pids = append(pids, pid)
+	}
+	sort.Ints(pids)
+	return pids, nil
+}
+
+// readBootTime 读取 /proc/stat 中的btime，即系统启动的时间
+func readBootTime() (time.Time, error) {
+	f, err := os.Open("/proc/stat")
+	if err != nil {
+		return time.Time{}, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 2 && fields[0] == "btime" {
+			sec, err := strconv.ParseInt(fields[1], 10, 64)
+			if err != nil {
+				return time.Time{}, err
+			}
+			return time.Unix(sec, 0), nil
+		}
+	}
+	return time.Time{}, errors.New("btime not found in /proc/stat")
+}
+
+func readProcInfo(pid int, bootTime time.Time) (*procInfo, error) {
+	dir := fmt.Sprintf("/proc/%d", pid)
+
+	stat, err := ioutil.ReadFile(path.Join(dir, "stat"))
+	if err != nil {
+		return nil, err
+	}
+	p, comm, err := parseProcStat(string(stat), bootTime)
+	if err != nil {
+		return nil, fmt.Errorf("parse %s/stat error: %v", dir, err)
+	}
+	p.pid = pid
+
+	status, err := ioutil.ReadFile(path.Join(dir, "status"))
+	if err != nil {
+		return nil, err
+	}
+	p.uid, p.cpid = parseProcStatus(string(status))
+
+	// 内核线程或者僵尸进程的cmdline是空的，和ps一样显示成 [comm]
+	cmdline, err := ioutil.ReadFile(path.Join(dir, "cmdline"))
+	if err != nil {
+		return nil, err
+	}
+	p.cmd = strings.TrimSpace(strings.ReplaceAll(string(cmdline), "\x00", " "))
+	if p.cmd == "" {
+		p.cmd = "[" + comm + "]"
+	}
+	return p, nil
+}
+
+// parseProcStat 解析 /proc/${pid}/stat，格式是 pid (comm) state ppid pgrp session tty_nr ...，
+// comm 中可能有空格和括号，所以从最后一个右括号之后开始按空格切分
+func parseProcStat(stat string, bootTime time.Time) (*procInfo, string, error) {
+	start := strings.IndexByte(stat, '(')
+	end := strings.LastIndexByte(stat, ')')
+	if start < 0 || end < start {
+		return nil, "", errors.New("missing comm")
+	}
+	comm := stat[start+1 : end]
+
+	// fields[0]是state，对应 man 5 proc 中的第3个字段
+	fields := strings.Fields(stat[end+1:])
+	if len(fields) < 20 {
+		return nil, "", fmt.Errorf("expect at least 22 fields, got %d", len(fields)+2)
+	}
+
+	nums := make(map[int]uint64)
+	for _, i := range []int{1, 4, 11, 12, 19} {
+		n, err := strconv.ParseUint(fields[i], 10, 64)
+		if err != nil {
+			return nil, "", err
+		}
+		nums[i] = n
+	}
+
+	return &procInfo{
+		state: fields[0],
+		ppid:  int(nums[1]),
+		tty:   ttyName(nums[4]),
+		cpu:   time.Duration(nums[11]+nums[12]) * time.Second / clockTicks,
+		start: bootTime.Add(time.Duration(nums[19]) * time.Second / clockTicks),
+	}, comm, nil
+}
+
+// parseProcStatus 从 /proc/${pid}/status 中读取真实uid和容器内的pid，
+// NSpid 依次是进程在各级pid namespace中的pid，最后一个是容器内的pid
+func parseProcStatus(status string) (uid, cpid string) {
+	uid, cpid = "?", "?"
+	for _, line := range strings.Split(status, "\n") {
+		kv := strings.SplitN(line, ":", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		fields := strings.Fields(kv[1])
+		if len(fields) == 0 {
+			continue
+		}
+
+		switch kv[0] {
+		case "Uid":
+			uid = fields[0]
+		case "NSpid":
+			cpid = fields[len(fields)-1]
+		}
+	}
+	return uid, cpid
+}
+
+// ttyName 把stat中的tty_nr转换成终端名，目前只识别pty(/dev/pts/N)
+func ttyName(ttyNr uint64) string {
+	if ttyNr == 0 {
+		return "?"
+	}
+
+	major := (ttyNr >> 8) & 0xfff
+	minor := (ttyNr & 0xff) | ((ttyNr >> 12) & 0xfff00)
+	// Unix98 pty的主设备号是136到143
+	if major >= 136 && major <= 143 {
+		return fmt.Sprintf("pts/%d", (major-136)*256+minor)
+	}
+	return fmt.Sprintf("%d:%d", major, minor)
+}
+
+// formatCPUTime 按ps的格式显示累计CPU时间 [DD-]HH:MM:SS
+func formatCPUTime(d time.Duration) string {
+	sec := int64(d / time.Second)
+	days := sec / 86400
+	s := fmt.Sprintf("%02d:%02d:%02d", sec%86400/3600, sec%3600/60, sec%60)
+	if days > 0 {
+		s = fmt.Sprintf("%d-%s", days, s)
+	}
+	return s
+}
@@ -0,0 +1,137 @@
+package cmd
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseProcStat(t *testing.T) {
+	boot := time.Unix(1600000000, 0)
+	tests := []struct {
+		stat  string
+		comm  string
+		state string
+		ppid  int
+		tty   string
+		cpu   time.Duration
+		start time.Time
+	}{
+		{
+			stat:  "1 (sh) S 0 1 1 34816 1 4194560 120 0 0 0 150 50 0 0 20 0 1 0 5000 4427776 200 18446744073709551615",
+			comm:  "sh",
+			state: "S",
+			ppid:  0,
+			tty:   "pts/0",
+			cpu:   2 * time.Second,
+			start: boot.Add(50 * time.Second),
+		},
+		{
+			// comm 中可以有空格和括号，以最后一个右括号为准
+			stat:  "42 (my (odd) prog) R 1 42 1 0 -1 4194304 10 0 0 0 360000 0 0 0 20 0 1 0 123 0 0",
+			comm:  "my (odd) prog",
+			state: "R",
+			ppid:  1,
+			tty:   "?",
+			cpu:   time.Hour,
+			start: boot.Add(1230 * time.Millisecond),
+		},
+		{
+			stat:  "7 () Z 1 7 7 0 -1 0 0 0 0 0 0 0 0 0 20 0 1 0 0",
+			comm:  "",
+			state: "Z",
+			ppid:  1,
+			tty:   "?",
+			start: boot,
+		},
+	}
+	for _, tt := range tests {
+		info, comm, err := parseProcStat(tt.stat, boot)
+		if err != nil {
+			t.Errorf("parseProcStat(%q) error: %v", tt.stat, err)
+			continue
+		}
+		if comm != tt.comm || info.state != tt.state || info.ppid != tt.ppid || info.tty != tt.tty ||
+			info.cpu != tt.cpu || !info.start.Equal(tt.start) {
+			t.Errorf("parseProcStat(%q) = %+v %q", tt.stat, info, comm)
+		}
+	}
+
+	for _, stat := range []string{
+		"",
+		"1 sh S 0 1",
+		"1 (sh) S 0 1 1 0",
+		"1 (sh) S x 1 1 0 -1 0 0 0 0 0 0 0 0 0 20 0 1 0 0",
+	} {
+		if _, _, err := parseProcStat(stat, boot); err == nil {
+			t.Errorf("parseProcStat(%q) expected error", stat)
+		}
+	}
+}
+
+func TestParseProcStatus(t *testing.T) {
+	tests := []struct {
+		status string
+		uid    string
+		cpid   string
+	}{
+		{
+			status: "Name:\tsleep\nState:\tS (sleeping)\nPid:\t4242\nUid:\t1000\t1000\t1000\t1000\nGid:\t1000\t1000\t1000\t1000\nNSpid:\t4242\t7\n",
+			uid:    "1000",
+			cpid:   "7",
+		},
+		{
+			status: "Name:\tinit\nUid:\t0\t0\t0\t0\nNSpid:\t1\n",
+			uid:    "0",
+			cpid:   "1",
+		},
+		{
+			// 旧内核没有 NSpid
+			status: "Name:\tsh\nUid:\t100000\t100000\t100000\t100000\n",
+			uid:    "100000",
+			cpid:   "?",
+		},
+		{
+			status: "",
+			uid:    "?",
+			cpid:   "?",
+		},
+	}
+	for _, tt := range tests {
+		uid, cpid := parseProcStatus(tt.status)
+		if uid != tt.uid || cpid != tt.cpid {
+			t.Errorf("parseProcStatus(%q) = %q %q, want %q %q", tt.status, uid, cpid, tt.uid, tt.cpid)
+		}
+	}
+}
+
+func TestTTYName(t *testing.T) {
+	tests := map[uint64]string{
+		0:                        "?",
+		136<<8 | 3:               "pts/3",
+		137<<8 | 44:              "pts/300",
+		1<<20 | 136<<8 | 44:      "pts/300",
+		4<<8 | 1:                 "4:1",
+		1<<20 | 4<<8 | 1:         "4:257",
+		143<<8 | 255 | 0xfff<<20: "pts/1050367",
+	}
+	for nr, want := range tests {
+		if got := ttyName(nr); got != want {
+			t.Errorf("ttyName(%d) = %q, want %q", nr, got, want)
+		}
+	}
+}
+
+func TestFormatCPUTime(t *testing.T) {
+	tests := map[time.Duration]string{
+		0:                       "00:00:00",
+		1500 * time.Millisecond: "00:00:01",
+		61 * time.Second:        "00:01:01",
+		25*time.Hour + 2*time.Minute + 3*time.Second: "1-01:02:03",
+		100 * 24 * time.Hour:                         "100-00:00:00",
+	}
+	for d, want := range tests {
+		if got := formatCPUTime(d); got != want {
+			t.Errorf("formatCPUTime(%v) = %q, want %q", d, got, want)
+		}
+	}
+}
@@ -0,0 +1,228 @@
+package container
+
+import (
+	"io"
+	"net"
+	"os"
+	"path"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/devhg/ddocker/logger"
+)
+
+// ContainerIO 是宿主机一侧的容器标准输入输出，由监控进程持有。
+// -it 时 Stdin 和 Stdout 都是pty的master，容器的stderr也输出到终端，Stderr 为nil；
+// 否则是分别连接容器标准输入、标准输出和标准错误的管道
+type ContainerIO struct {
+	Console *Console
+	Stdin   io.WriteCloser
+	Stdout  io.ReadCloser
+	Stderr  io.ReadCloser
+
+	// 交给容器进程的一端 [stdin, stdout, stderr]，容器进程启动后宿主机一侧就要关闭，
+	// 这样容器里所有进程退出后，读取 Stdout 和 Stderr 才会结束
+	childFiles []*os.File
+}
+
+func newContainerIO(tty bool) (*ContainerIO, error) {
+	if tty {
+		console, err := NewConsole()
+		if err != nil {
+			return nil, err
+		}
+		return &ContainerIO{
+			Console:    console,
+			Stdin:      console.Master,
+			Stdout:     console.Master,
+			childFiles: []*os.File{console.Slave, console.Slave, console.Slave},
+		}, nil
+	}
+
+	// [stdin, stdout, stderr] 各自的 [read, write]
+	var pipes [3][2]*os.File
+	for i := range pipes {
+		r, w, err := os.Pipe()
+		if err != nil {
+			for _, p := range pipes[:i] {
+				p[0].Close()
+				p[1].Close()
+			}
+			return nil, err
+		}
+		pipes[i] = [2]*os.File{r, w}
+	}
+
+	return &ContainerIO{
+		Stdin:      pipes[0][1],
+		Stdout:     pipes[1][0],
+		Stderr:     pipes[2][0],
+		childFiles: []*os.File{pipes[0][0], pipes[1][1], pipes[2][1]},
+	}, nil
+}
+
+// CloseChildFiles 在容器进程启动后关闭宿主机一侧持有的容器端文件
+func (c *ContainerIO) CloseChildFiles() {
+	for _, f := range c.childFiles {
+		f.Close()
+	}
+}
+
+// close 关闭宿主机一侧的输入输出，-it 时三者是同一个pty master，重复关闭没有影响
+func (c *ContainerIO) close() {
+	c.Stdin.Close()
+	c.Stdout.Close()
+	if c.Stderr != nil {
+		c.Stderr.Close()
+	}
+}
+
+// ServeContainerIO 把容器的stdout和stderr按行交给logConfig指定的日志驱动，json-file驱动记录到 std.log；
+// 同时把输出广播给所有attach上来的客户端，客户端的输入写到容器的标准输入。
+// 客户端通过 /var/run/ddocker/${containerID}/attach.sock 连接。
+// 返回的函数在容器退出后调用，等待容器的输出全部写完，然后关闭监听和所有客户端
+func ServeContainerIO(containerID string, cio *ContainerIO, logConfig *logger.Config) (func(), error) {
+	// /var/run/ddocker/${containerID}
+	dir := path.Join(DefaultInfoLocation, containerID)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	driver, err := logger.New(&logger.Info{
+		ContainerID: containerID,
+		// /var/run/ddocker/${containerID}/std.log
+		LogPath: path.Join(dir, StdLogFileName),
+		Config:  logConfig,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	server, err := newAttachServer(containerID, cio.Stdin)
+	if err != nil {
+		driver.Close()
+		return nil, err
+	}
+	go server.serve()
+
+	var wg sync.WaitGroup
+	copyStream := func(stream string, r io.Reader) {
+		defer wg.Done()
+		w := &logWriter{w: logger.NewLineWriter(driver, stream), stream: stream}
+		_, _ = io.Copy(io.MultiWriter(w, server), r)
+		if err := w.w.Flush(); err != nil {
+			logrus.Errorf("write container %s log error: %v", stream, err)
+		}
+	}
+
+	wg.Add(1)
+	go copyStream(logger.Stdout, cio.Stdout)
+	if cio.Stderr != nil {
+		wg.Add(1)
+		go copyStream(logger.Stderr, cio.Stderr)
+	}
+
+	return func() {
+		wg.Wait()
+		server.close()
+		driver.Close()
+		cio.close()
+	}, nil
+}
+
+// logWriter 写日志失败时只记录一次错误，不能因为日志驱动出错（比如syslog不可用）就停止读取容器的输出，
+// 否则容器写满管道后会阻塞
+type logWriter struct {
+	w      *logger.LineWriter
+	stream string
+	failed bool
+}
+
+func (l *logWriter) Write(p []byte) (int, error) {
+	if _, err := l.w.Write(p); err != nil && !l.failed {
+		l.failed = true
+		logrus.Errorf("write container %s log error: %v", l.stream, err)
+	}
+	return len(p), nil
+}
+
+// attachServer 在容器的unix socket上接受attach客户端
+type attachServer struct {
+	listener net.Listener
+	stdin    io.Writer
+
+	mu      sync.Mutex
+	clients map[net.Conn]struct{}
+}
+
+func newAttachServer(containerID string, stdin io.Writer) (*attachServer, error) {
+	// /var/run/ddocker/${containerID}/attach.sock
+	sock := path.Join(DefaultInfoLocation, containerID, AttachSocketName)
+	_ = os.Remove(sock)
+
+	listener, err := net.Listen("unix", sock)
+	if err != nil {
+		return nil, err
+	}
+
+	return &attachServer{
+		listener: listener,
+		stdin:    stdin,
+		clients:  make(map[net.Conn]struct{}),
+	}, nil
+}
+
+func (s *attachServer) serve() {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+
+		s.mu.Lock()
+		s.clients[conn] = struct{}{}
+		s.mu.Unlock()
+
+		// 客户端断开(detach)时只移除这个客户端，容器的标准输入保持打开
+		go func() {
+			_, _ = io.Copy(s.stdin, conn)
+			s.remove(conn)
+		}()
+	}
+}
+
+// Write 把容器的输出广播给所有客户端，写失败的客户端会被断开，
+// 始终返回成功，避免影响容器日志的写入
+func (s *attachServer) Write(p []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for conn := range s.clients {
+		if _, err := conn.Write(p); err != nil {
+			logrus.Warnf("write to attach client error: %v", err)
+			conn.Close()
+			delete(s.clients, conn)
+		}
+	}
+	return len(p), nil
+}
+
+func (s *attachServer) remove(conn net.Conn) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	conn.Close()
+	delete(s.clients, conn)
+}
+
+func (s *attachServer) close() {
+	s.listener.Close()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for conn := range s.clients {
+		conn.Close()
+		delete(s.clients, conn)
+	}
+}
@@ -0,0 +1,221 @@
+package container
+
+import (
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strconv"
+	"strings"
+
+	"golang.org/x/sys/unix"
+)
+
+// capabilities 所有capability的名字和编号，对应 linux/capability.h
+var capabilities = map[string]uint{
+	"CAP_CHOWN":              0,
+	"CAP_DAC_OVERRIDE":       1,
+	"CAP_DAC_READ_SEARCH":    2,
+	"CAP_FOWNER":             3,
+	"CAP_FSETID":             4,
+	"CAP_KILL":               5,
+	"CAP_SETGID":             6,
+	"CAP_SETUID":             7,
+	"CAP_SETPCAP":            8,
+	"CAP_LINUX_IMMUTABLE":    9,
+	"CAP_NET_BIND_SERVICE":   10,
+	"CAP_NET_BROADCAST":      11,
+	"CAP_NET_ADMIN":          12,
+	"CAP_NET_RAW":            13,
+	"CAP_IPC_LOCK":           14,
+	"CAP_IPC_OWNER":          15,
+	"CAP_SYS_MODULE":         16,
+	"CAP_SYS_RAWIO":          17,
+	"CAP_SYS_CHROOT":         18,
+	"CAP_SYS_PTRACE":         19,
+	"CAP_SYS_PACCT":          20,
+	"CAP_SYS_ADMIN":          21,
+	"CAP_SYS_BOOT":           22,
+	"CAP_SYS_NICE":           23,
+	"CAP_SYS_RESOURCE":       24,
+	"CAP_SYS_TIME":           25,
+	"CAP_SYS_TTY_CONFIG":     26,
+	"CAP_MKNOD":              27,
+	"CAP_LEASE":              28,
+	"CAP_AUDIT_WRITE":        29,
+	"CAP_AUDIT_CONTROL":      30,
+	"CAP_SETFCAP":            31,
+	"CAP_MAC_OVERRIDE":       32,
+	"CAP_MAC_ADMIN":          33,
+	"CAP_SYSLOG":             34,
+	"CAP_WAKE_ALARM":         35,
+	"CAP_BLOCK_SUSPEND":      36,
+	"CAP_AUDIT_READ":         37,
+	"CAP_PERFMON":            38,
+	"CAP_BPF":                39,
+	"CAP_CHECKPOINT_RESTORE": 40,
+}
+
+// DefaultCapabilities 容器默认保留的capability，和docker的默认列表一致
+var DefaultCapabilities = []string{
+	"CAP_CHOWN",
+	"CAP_DAC_OVERRIDE",
+	"CAP_FSETID",
+	"CAP_FOWNER",
+	"CAP_MKNOD",
+	"CAP_NET_RAW",
+	"CAP_SETGID",
+	"CAP_SETUID",
+	"CAP_SETFCAP",
+	"CAP_SETPCAP",
+	"CAP_NET_BIND_SERVICE",
+	"CAP_SYS_CHROOT",
+	"CAP_KILL",
+	"CAP_AUDIT_WRITE",
+}
+
+// normalizeCapability 把 net_admin、NET_ADMIN、CAP_NET_ADMIN 统一成 CAP_NET_ADMIN
+func normalizeCapability(name string) (string, error) {
+	name = strings.ToUpper(name)
+	if name == "ALL" {
+		return name, nil
+	}
+	if !strings.HasPrefix(name, "CAP_") {
+		name = "CAP_" + name
+	}
+	if _, ok := capabilities[name]; !ok {
+		return "", fmt.Errorf("unknown capability %q", name)
+	}
+	return name, nil
+}
+
+// TweakCapabilities 在默认列表的基础上先去掉 --cap-drop 再加上 --cap-add，
+// ALL 表示所有capability，--privileged 时以所有capability为基础
+func TweakCapabilities(privileged bool, add, drop []string) ([]string, error) {
+	set := make(map[string]bool)
+	base := DefaultCapabilities
+	if privileged {
+		base = allCapabilities()
+	}
+	for _, c := range base {
+		set[c] = true
+	}
+
+	for _, c := range drop {
+		name, err := normalizeCapability(c)
+		if err != nil {
+			return nil, err
+		}
+		if name == "ALL" {
+			set = make(map[string]bool)
+			continue
+		}
+		delete(set, name)
+	}
+
+	for _, c := range add {
+		name, err := normalizeCapability(c)
+		if err != nil {
+			return nil, err
+		}
+		if name == "ALL" {
+			for _, all := range allCapabilities() {
+				set[all] = true
+			}
+			continue
+		}
+		set[name] = true
+	}
+
+	var caps []string
+	for c := range set {
+		caps = append(caps, c)
+	}
+	sort.Slice(caps, func(i, j int) bool { return capabilities[caps[i]] < capabilities[caps[j]] })
+	return caps, nil
+}
+
+func allCapabilities() []string {
+	var caps []string
+	for c := range capabilities {
+		caps = append(caps, c)
+	}
+	return caps
+}
+
+// CapabilityMask 把capability列表转换成位图，第n位对应编号为n的capability
+func CapabilityMask(caps []string) uint64 {
+	var mask uint64
+	for _, c := range caps {
+		if n, ok := capabilities[c]; ok {
+			mask |= 1 << n
+		}
+	}
+	return mask
+}
+
+// lastCapability 当前内核支持的最大capability编号
+func lastCapability() uint {
+	b, err := ioutil.ReadFile("/proc/sys/kernel/cap_last_cap")
+	if err != nil {
+		return capabilities["CAP_AUDIT_READ"]
+	}
+	n, err := strconv.Atoi(strings.TrimSpace(string(b)))
+	if err != nil {
+		return capabilities["CAP_AUDIT_READ"]
+	}
+	return uint(n)
+}
+
+// applyCapabilities 从bounding set中去掉不在列表中的capability，并把进程的
+// effective、permitted集合设置成这个列表。inheritable集合保持为空，否则文件的inheritable
+// capability在execve之后不受bounding set限制（CVE-2022-24769）。execve之后root进程的capability
+// 不会超出bounding set。capability是线程级别的，调用者要保证之后在同一个线程上exec。
+// user不为空时同时切换到这个用户，非root用户exec之后没有capability
+func applyCapabilities(caps []string, user *execUser) error {
+	// 内核不支持的capability不能设置
+	last := lastCapability()
+	mask := CapabilityMask(caps) & (1<<(last+1) - 1)
+
+	// capset不能得到自己没有的capability，--privileged 时只保留当前进程拥有的全部capability
+	hdr := unix.CapUserHeader{Version: unix.LINUX_CAPABILITY_VERSION_3}
+	var data [2]unix.CapUserData
+	if err := unix.Capget(&hdr, &data[0]); err != nil {
+		return fmt.Errorf("capget error: %v", err)
+	}
+	mask &= uint64(data[1].Permitted)<<32 | uint64(data[0].Permitted)
+
+	// 清空ambient集合，避免exec之后通过ambient保留capability
+	if err := unix.Prctl(unix.PR_CAP_AMBIENT, unix.PR_CAP_AMBIENT_CLEAR_ALL, 0, 0, 0); err != nil && err != unix.EINVAL {
+		return fmt.Errorf("clear ambient capabilities error: %v", err)
+	}
+
+	// 修改bounding set需要CAP_SETPCAP，所以要在capset之前完成
+	for n := uint(0); n <= last; n++ {
+		if mask&(1<<n) != 0 {
+			continue
+		}
+		if err := unix.Prctl(unix.PR_CAPBSET_DROP, uintptr(n), 0, 0, 0); err != nil {
+			return fmt.Errorf("drop capability %d from bounding set error: %v", n, err)
+		}
+	}
+
+	// 切换用户需要的CAP_SETUID、CAP_SETGID可能不在保留的capability中，所以在capset之前切换。
+	// keepcaps使切换到非root用户之后permitted集合不被清空，exec时内核会重新计算
+	if user != nil {
+		if err := unix.Prctl(unix.PR_SET_KEEPCAPS, 1, 0, 0, 0); err != nil {
+			return fmt.Errorf("set keepcaps error: %v", err)
+		}
+		if err := switchUser(user); err != nil {
+			return err
+		}
+	}
+
+	for i := range data {
+		bits := uint32(mask >> (32 * uint(i)))
+		data[i] = unix.CapUserData{Effective: bits, Permitted: bits}
+	}
+	if err := unix.Capset(&hdr, &data[0]); err != nil {
+		return fmt.Errorf("capset error: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,37 @@
+package container
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestTweakCapabilities(t *testing.T) {
+	caps, err := TweakCapabilities(false, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// docker默认的capability位图
+	if mask := CapabilityMask(caps); mask != 0xa80425fb {
+		t.Errorf("default mask = %#x, want 0xa80425fb", mask)
+	}
+
+	caps, err = TweakCapabilities(false, []string{"net_admin", "CAP_SYS_PTRACE"}, []string{"ALL"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"CAP_NET_ADMIN", "CAP_SYS_PTRACE"}; !reflect.DeepEqual(caps, want) {
+		t.Errorf("got %v, want %v", caps, want)
+	}
+
+	caps, err = TweakCapabilities(true, nil, []string{"sys_admin"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(caps) != len(capabilities)-1 {
+		t.Errorf("privileged without CAP_SYS_ADMIN got %d capabilities, want %d", len(caps), len(capabilities)-1)
+	}
+
+	if _, err := TweakCapabilities(false, []string{"FOO"}, nil); err == nil {
+		t.Errorf("expected error for unknown capability")
+	}
+}
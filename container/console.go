@@ -0,0 +1,87 @@
+package container
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+
+	"github.com/devhg/ddocker/util"
+)
+
+// Console 是 -it 容器使用的伪终端(pty)，Master 留在宿主机一侧，
+// Slave 作为容器进程的标准输入输出和控制终端
+type Console struct {
+	Master *os.File
+	Slave  *os.File
+}
+
+// NewConsole 通过 /dev/ptmx 分配一对pty
+func NewConsole() (*Console, error) {
+	master, err := os.OpenFile("/dev/ptmx", os.O_RDWR|syscall.O_NOCTTY|syscall.O_CLOEXEC, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	// 解锁slave端，然后获取slave端的编号，对应 /dev/pts/${N}
+	if err := unix.IoctlSetPointerInt(int(master.Fd()), unix.TIOCSPTLCK, 0); err != nil {
+		master.Close()
+		return nil, fmt.Errorf("unlock pty error: %v", err)
+	}
+	n, err := unix.IoctlGetUint32(int(master.Fd()), unix.TIOCGPTN)
+	if err != nil {
+		master.Close()
+		return nil, fmt.Errorf("get pty number error: %v", err)
+	}
+
+	slave, err := os.OpenFile(fmt.Sprintf("/dev/pts/%d", n), os.O_RDWR|syscall.O_NOCTTY, 0)
+	if err != nil {
+		master.Close()
+		return nil, err
+	}
+
+	return &Console{Master: master, Slave: slave}, nil
+}
+
+// AttachTerminal 把当前终端切换成raw模式，在当前终端和pty的master之间复制数据，
+// 当前终端窗口大小变化(SIGWINCH)时同步到容器的终端上。
+// 返回的函数会等待容器的输出全部复制完，然后恢复当前终端的设置
+func (c *Console) AttachTerminal(in *os.File, out io.Writer) func() {
+	restore := func() {}
+	winch := make(chan os.Signal, 1)
+
+	if util.IsTerminal(in.Fd()) {
+		if r, err := util.MakeRaw(in.Fd()); err == nil {
+			restore = r
+		}
+
+		_ = util.CopyWinsize(c.Master.Fd(), in.Fd())
+		signal.Notify(winch, syscall.SIGWINCH)
+		go func() {
+			for range winch {
+				_ = util.CopyWinsize(c.Master.Fd(), in.Fd())
+			}
+		}()
+	}
+
+	go func() {
+		_, _ = io.Copy(c.Master, in)
+	}()
+
+	outputDone := make(chan struct{})
+	go func() {
+		_, _ = io.Copy(out, c.Master)
+		close(outputDone)
+	}()
+
+	return func() {
+		<-outputDone
+		signal.Stop(winch)
+		close(winch)
+		restore()
+		c.Master.Close()
+	}
+}
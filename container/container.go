@@ -1,15 +1,18 @@
 package container
 
 import (
+	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"runtime"
 	"strings"
 	"syscall"
 
 	"github.com/sirupsen/logrus"
+	"golang.org/x/sys/unix"
 )
 
 var (
@@ -17,8 +20,8 @@ var (
 	MntURL        = "/root/mnt/%s"
 	WriteLayerURL = "/root/writeLayer/%s"
 
-	// overlay use it
-	WorkDirURL = "/root/work"
+	// overlay use it，每个overlay挂载都要有自己的workdir，多个挂载共用时先挂载的会无法copy-up
+	WorkDirURL = "/root/work/%s"
 )
 
 // RunContainerInitProcess 是在容器内部执行的，也就是说代码执行到这里后，
@@ -30,12 +33,40 @@ var (
 // 这个系统调用的作用就是，将原来的init进程替换成用户自己的进程，这样当进入容器的时候，PID=1的程序就是我们指定的进程了。
 // 容器 === 进程。这其实也是目前docker使用容器引擎runC的实现方式之一
 func RunContainerInitProcess() error {
-	cmdArray := readUserCommand()
+	config, err := readInitConfig()
+	if err != nil {
+		return err
+	}
+	cmdArray := config.Args
 	// should omit nil check; len() for nil slices is defined as zero (S1009)   good!
 	if len(cmdArray) == 0 {
 		return fmt.Errorf("run container get user command error, cmdArray is nil")
 	}
-	setUpMount()
+	if err := setUpMount(config); err != nil {
+		logrus.Errorf("set up mount error %v", err)
+		return err
+	}
+	if config.Hostname != "" {
+		if err := syscall.Sethostname([]byte(config.Hostname)); err != nil {
+			logrus.Errorf("set hostname error %v", err)
+			return err
+		}
+	}
+	if config.Workdir != "" {
+		if err := syscall.Chdir(config.Workdir); err != nil {
+			logrus.Errorf("change to workdir %s error %v", config.Workdir, err)
+			return err
+		}
+	}
+
+	// pivot_root之后在容器自己的 /etc/passwd 和 /etc/group 中查找用户
+	var user *execUser
+	if config.User != "" {
+		if user, err = lookupUser(config.User); err != nil {
+			logrus.Errorf("lookup user error %v", err)
+			return err
+		}
+	}
 
 	// 在系统PATH中寻找命令的绝对路径
 	cmdPath, err := exec.LookPath(cmdArray[0])
@@ -45,23 +76,60 @@ func RunContainerInitProcess() error {
 	}
 	logrus.Infof("found path is %s", cmdPath)
 	logrus.Infoln(cmdPath, cmdArray)
+
+	// 提高hard limit需要CAP_SYS_RESOURCE，在去掉capability之前设置
+	if err := setRlimits(config.Ulimits); err != nil {
+		logrus.Errorf("set ulimits error %v", err)
+		return err
+	}
+
+	// capability和seccomp过滤器都是线程级别的，设置之后必须在同一个线程上exec
+	runtime.LockOSThread()
+	// 没有设置no_new_privs时安装seccomp过滤器需要CAP_SYS_ADMIN，所以在去掉capability之前安装，
+	// 之后的capset、prctl和execve也要经过过滤器，配置中必须允许这几个系统调用。
+	// 设置了no_new_privs时不需要capability，在exec之前最后安装
+	if config.NoNewPrivileges {
+		if err := unix.Prctl(unix.PR_SET_NO_NEW_PRIVS, 1, 0, 0, 0); err != nil {
+			logrus.Errorf("set no_new_privs error %v", err)
+			return err
+		}
+	}
+	if config.Seccomp != nil && !config.NoNewPrivileges {
+		if err := installSeccomp(config.Seccomp, config.Capabilities); err != nil {
+			logrus.Errorf("install seccomp filter error %v", err)
+			return err
+		}
+	}
+	if err := applyCapabilities(config.Capabilities, user); err != nil {
+		logrus.Errorf("apply capabilities error %v", err)
+		return err
+	}
+	if config.Seccomp != nil && config.NoNewPrivileges {
+		if err := installSeccomp(config.Seccomp, config.Capabilities); err != nil {
+			logrus.Errorf("install seccomp filter error %v", err)
+			return err
+		}
+	}
 	if err := syscall.Exec(cmdPath, cmdArray, os.Environ()); err != nil {
 		logrus.Errorln(err.Error())
 	}
 	return nil
 }
 
-func readUserCommand() []string {
+func readInitConfig() (*InitConfig, error) {
 	// 一个进程的创建，默认有三个文件描述符，[标准输入 标准输出 标准错误]
 	// uintptr(3) 是指index=3的文件描述符，也就是传进来管道的一端(readPipe)
 	pipe := os.NewFile(uintptr(3), "pipe")
 	msg, err := ioutil.ReadAll(pipe)
 	if err != nil {
-		logrus.Errorf("init read pipe error %v", err)
-		return nil
+		return nil, fmt.Errorf("init read pipe error %v", err)
+	}
+
+	config := &InitConfig{}
+	if err := json.Unmarshal(msg, config); err != nil {
+		return nil, fmt.Errorf("init parse config error %v", err)
 	}
-	msgStr := string(msg)
-	return strings.Split(msgStr, " ")
+	return config, nil
 }
 
 // setUpMount Init 挂载点
@@ -71,21 +139,131 @@ func readUserCommand() []string {
 // syscall.MS_NOSUID 本文件系统运行程序，禁止set-user-ID或set-group-ID
 // syscall.MS_NODEV  所有mount的系统都会默认设定的参数
 //
-func setUpMount() {
+func setUpMount(config *InitConfig) error {
 	pwd, err := os.Getwd()
 	if err != nil {
-		logrus.Errorf("get current location error: %v", err)
-		return
+		return fmt.Errorf("get current location error: %v", err)
 	}
 
 	logrus.Info("current location is: ", pwd)
+
+	if len(config.Mounts) > 0 {
+		if err := mountAll(pwd, config.Mounts); err != nil {
+			return err
+		}
+	}
+
+	// user namespace中不能mknod，设备文件要从宿主机的 /dev bind挂载，所以在pivot_root之前准备好
+	if err := setupDev(pwd, config.Devices); err != nil {
+		return err
+	}
+
+	// 屏蔽和只读的路径都在proc等文件系统挂载之后、pivot_root之前处理，这时还能用宿主机的 /dev/null
+	for _, p := range config.MaskedPaths {
+		if err := maskPath(pwd, p); err != nil {
+			return err
+		}
+	}
+	for _, p := range config.ReadonlyPaths {
+		if err := readonlyPath(pwd, p); err != nil {
+			return err
+		}
+	}
+
+	// pivot_root失败时不能继续，否则后面的只读挂载和用户命令都会作用在宿主机的根目录上
 	if err = pivotRoot(pwd); err != nil {
-		logrus.Warn(err)
+		return err
+	}
+
+	// 工作目录不存在时创建。在pivot_root之后创建，镜像中的符号链接就不会指到宿主机上，
+	// 要在 --read-only 把根目录改成只读之前完成
+	// 指向不存在目录的符号链接要先解析，MkdirAll不会穿过它创建目录
+	if config.Workdir != "" {
+		workdir, err := securePath("/", config.Workdir)
+		if err != nil {
+			return fmt.Errorf("resolve workdir %s error: %v", config.Workdir, err)
+		}
+		if err := os.MkdirAll(workdir, 0755); err != nil {
+			return fmt.Errorf("create workdir %s error: %v", config.Workdir, err)
+		}
 	}
 
-	defaultMountFlags := syscall.MS_NOEXEC | syscall.MS_NOSUID | syscall.MS_NODEV
-	_ = syscall.Mount("proc", "/proc", "proc", uintptr(defaultMountFlags), "")
-	_ = syscall.Mount("tmpfs", "/dev", "tmpfs", syscall.MS_NOSUID|syscall.MS_STRICTATIME, "mode=755")
+	// --read-only 只把根目录改成只读，数据卷和 /proc、/dev 等挂载点依然可写
+	if config.ReadonlyRootfs {
+		if err := remountReadonly("/"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// mountAll 依次挂载父进程传过来的文件系统，第一个一般是挂载到根目录的overlay
+func mountAll(root string, mounts []Mount) error {
+	for _, m := range mounts {
+		// 镜像中的 /etc/hosts 等可能是指向绝对路径的符号链接，要在容器根目录中解析，
+		// 否则会在宿主机上创建文件或挂载。每次挂载之后再解析下一个，前面的挂载可能改变路径
+		target, err := securePath(root, m.Target)
+		if err != nil {
+			return fmt.Errorf("invalid mount point %s: %v", m.Target, err)
+		}
+		if err := prepareMountPoint(m, target); err != nil {
+			return fmt.Errorf("create mount point %s error: %v", target, err)
+		}
+		if err := mount(m, target); err != nil {
+			return fmt.Errorf("mount %s to %s error: %v", m.Source, target, err)
+		}
+
+		// 挂载到当前目录上之后，当前目录还指向下面被覆盖的目录，需要重新进入一次
+		if m.Target == "" || m.Target == "/" {
+			if err := syscall.Chdir(root); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// prepareMountPoint 创建挂载点，bind挂载文件时挂载点也要是文件，例如 /etc/hosts
+func prepareMountPoint(m Mount, target string) error {
+	if m.Flags&syscall.MS_BIND != 0 {
+		if info, err := os.Stat(m.Source); err == nil && !info.IsDir() {
+			if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+				return err
+			}
+			f, err := os.OpenFile(target, os.O_CREATE, 0644)
+			if err != nil {
+				return err
+			}
+			return f.Close()
+		}
+	}
+	return os.MkdirAll(target, 0755)
+}
+
+// mount 挂载一个文件系统，处理user namespace中的限制
+func mount(m Mount, target string) error {
+	err := syscall.Mount(m.Source, target, m.Type, m.Flags, m.Data)
+	switch {
+	case err == nil:
+	case m.Type == "sysfs" && err == syscall.EPERM:
+		// 和宿主机共用network namespace时不能挂载新的sysfs，改为bind挂载宿主机的 /sys
+		if err := syscall.Mount("/sys", target, "", syscall.MS_BIND|syscall.MS_REC, ""); err != nil {
+			return err
+		}
+		m.Flags |= syscall.MS_BIND
+	case m.Type == "devpts" && err == syscall.EINVAL:
+		// rootless容器的user namespace中没有映射tty组(gid 5)
+		data := strings.Replace(m.Data, ",gid=5", "", 1)
+		return syscall.Mount(m.Source, target, m.Type, m.Flags, data)
+	default:
+		return err
+	}
+
+	// bind挂载时mount会忽略只读标志，要再重新挂载一次
+	if m.Flags&syscall.MS_BIND != 0 && m.Flags&syscall.MS_RDONLY != 0 {
+		return remountReadonly(target)
+	}
+	return nil
 }
 
 // pivotRoot 改变当前的root文件系统，对应pivot_root系统调用
@@ -101,9 +279,10 @@ func pivotRoot(root string) error {
 	}
 
 	// 创建rootfs/.pivot_root 存储 old_root
+	// 上次异常退出时可能留下了这个目录
 	pivotDir := filepath.Join(root, ".pivot_root")
-	if err := os.Mkdir(pivotDir, 0777); err != nil {
-		return err
+	if err := os.Mkdir(pivotDir, 0777); err != nil && !os.IsExist(err) {
+		return fmt.Errorf("func[pivotRoot] create pivot dir error: %v", err)
 	}
 
 	// pivot_root 到新的rootfs，old_root 现在挂载在rootfs/.pivot_root上
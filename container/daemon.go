@@ -0,0 +1,36 @@
+package container
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+)
+
+// DaemonConfigPath 全局默认配置的位置，格式和docker的daemon.json兼容，
+// rootless模式下在 $XDG_CONFIG_HOME/ddocker/daemon.json
+var DaemonConfigPath = "/etc/ddocker/daemon.json"
+
+// DaemonConfig 创建容器时使用的全局默认配置，例如
+//
+//	{"default-ulimits": {"nofile": {"Name": "nofile", "Soft": 65536, "Hard": 65536}}}
+type DaemonConfig struct {
+	// 所有容器默认的资源限制，--ulimit 指定的同名限制会覆盖它
+	DefaultUlimits map[string]*Ulimit `json:"default-ulimits,omitempty"`
+}
+
+// LoadDaemonConfig 读取全局默认配置，文件不存在时返回空配置
+func LoadDaemonConfig() (*DaemonConfig, error) {
+	config := &DaemonConfig{}
+	b, err := ioutil.ReadFile(DaemonConfigPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return config, nil
+		}
+		return nil, err
+	}
+	if err := json.Unmarshal(b, config); err != nil {
+		return nil, fmt.Errorf("parse %s error: %v", DaemonConfigPath, err)
+	}
+	return config, nil
+}
@@ -0,0 +1,298 @@
+package container
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// DeviceRule cgroup devices子系统的一条规则，Major或Minor为-1时表示任意编号
+type DeviceRule struct {
+	Type        string `json:"type"` // c 字符设备，b 块设备，a 所有设备
+	Major       int64  `json:"major"`
+	Minor       int64  `json:"minor"`
+	Permissions string `json:"permissions"` // r 读，w 写，m mknod
+}
+
+// String 转换成 devices.allow 的格式，例如 "c 1:3 rwm"
+func (r *DeviceRule) String() string {
+	num := func(n int64) string {
+		if n == -1 {
+			return "*"
+		}
+		return strconv.FormatInt(n, 10)
+	}
+	return fmt.Sprintf("%s %s:%s %s", r.Type, num(r.Major), num(r.Minor), r.Permissions)
+}
+
+// Device 容器 /dev 下的设备文件
+type Device struct {
+	DeviceRule
+	Path     string      `json:"path"` // 容器内的路径
+	FileMode os.FileMode `json:"file_mode"`
+	UID      uint32      `json:"uid"`
+	GID      uint32      `json:"gid"`
+}
+
+// DefaultDevices 每个容器都有的设备文件
+var DefaultDevices = []*Device{
+	{Path: "/dev/null", DeviceRule: DeviceRule{Type: "c", Major: 1, Minor: 3, Permissions: "rwm"}, FileMode: 0666},
+	{Path: "/dev/zero", DeviceRule: DeviceRule{Type: "c", Major: 1, Minor: 5, Permissions: "rwm"}, FileMode: 0666},
+	{Path: "/dev/full", DeviceRule: DeviceRule{Type: "c", Major: 1, Minor: 7, Permissions: "rwm"}, FileMode: 0666},
+	{Path: "/dev/random", DeviceRule: DeviceRule{Type: "c", Major: 1, Minor: 8, Permissions: "rwm"}, FileMode: 0666},
+	{Path: "/dev/urandom", DeviceRule: DeviceRule{Type: "c", Major: 1, Minor: 9, Permissions: "rwm"}, FileMode: 0666},
+	{Path: "/dev/tty", DeviceRule: DeviceRule{Type: "c", Major: 5, Minor: 0, Permissions: "rwm"}, FileMode: 0666},
+}
+
+// defaultDeviceRules 除了默认设备文件之外放行的设备，和runc的默认规则一致
+var defaultDeviceRules = []*DeviceRule{
+	// 允许mknod创建任何设备文件，但是不能读写
+	{Type: "c", Major: -1, Minor: -1, Permissions: "m"},
+	{Type: "b", Major: -1, Minor: -1, Permissions: "m"},
+	// /dev/console
+	{Type: "c", Major: 5, Minor: 1, Permissions: "rwm"},
+	// /dev/pts/ 和 /dev/ptmx
+	{Type: "c", Major: 136, Minor: -1, Permissions: "rwm"},
+	{Type: "c", Major: 5, Minor: 2, Permissions: "rwm"},
+	// /dev/net/tun
+	{Type: "c", Major: 10, Minor: 200, Permissions: "rwm"},
+}
+
+// CgroupDeviceRules 容器的cgroup设备规则，--privileged 时放行所有设备
+func CgroupDeviceRules(devices []*Device, privileged bool) []string {
+	if privileged {
+		return []string{"a *:* rwm"}
+	}
+
+	var rules []string
+	for _, r := range defaultDeviceRules {
+		rules = append(rules, r.String())
+	}
+	for _, d := range devices {
+		rules = append(rules, d.String())
+	}
+	return rules
+}
+
+// ParseDevice 解析 --device 的参数 host[:container[:permissions]]，
+// 没有指定容器内路径时和宿主机路径相同，权限默认是rwm
+func ParseDevice(spec string) (*Device, error) {
+	parts := strings.Split(spec, ":")
+	if len(parts) > 3 || parts[0] == "" {
+		return nil, fmt.Errorf("invalid device %q, expect host[:container[:permissions]]", spec)
+	}
+
+	hostPath := parts[0]
+	containerPath := hostPath
+	permissions := "rwm"
+	switch len(parts) {
+	case 2:
+		// 第二段只有权限字符时是 host:permissions
+		if validDevicePermissions(parts[1]) {
+			permissions = parts[1]
+		} else {
+			containerPath = parts[1]
+		}
+	case 3:
+		containerPath = parts[1]
+		permissions = parts[2]
+	}
+	if !validDevicePermissions(permissions) {
+		return nil, fmt.Errorf("invalid device permissions %q in %q", permissions, spec)
+	}
+	if !filepath.IsAbs(containerPath) {
+		return nil, fmt.Errorf("invalid device %q, container path must be absolute", spec)
+	}
+
+	var st unix.Stat_t
+	if err := unix.Stat(hostPath, &st); err != nil {
+		return nil, fmt.Errorf("stat device %s error: %v", hostPath, err)
+	}
+	var typ string
+	switch st.Mode & unix.S_IFMT {
+	case unix.S_IFCHR:
+		typ = "c"
+	case unix.S_IFBLK:
+		typ = "b"
+	default:
+		return nil, fmt.Errorf("%s is not a device", hostPath)
+	}
+
+	return &Device{
+		DeviceRule: DeviceRule{
+			Type:        typ,
+			Major:       int64(unix.Major(uint64(st.Rdev))),
+			Minor:       int64(unix.Minor(uint64(st.Rdev))),
+			Permissions: permissions,
+		},
+		Path:     filepath.Clean(containerPath),
+		FileMode: os.FileMode(st.Mode & 0777),
+		UID:      st.Uid,
+		GID:      st.Gid,
+	}, nil
+}
+
+func validDevicePermissions(p string) bool {
+	if p == "" {
+		return false
+	}
+	for _, c := range p {
+		if c != 'r' && c != 'w' && c != 'm' {
+			return false
+		}
+	}
+	return true
+}
+
+// createDevices 在容器根目录root下创建设备文件。user namespace中不能mknod，
+// 改为bind挂载宿主机上同样编号的设备，需要在pivot_root之前调用
+func createDevices(root string, devices []*Device) error {
+	bind := runningInUserNS()
+
+	// mknod 创建的文件权限受umask影响
+	oldMask := unix.Umask(0)
+	defer unix.Umask(oldMask)
+
+	for _, d := range devices {
+		target, err := securePath(root, d.Path)
+		if err != nil {
+			return err
+		}
+		if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+			return err
+		}
+
+		if bind {
+			err = bindDevice(target, d)
+		} else {
+			err = mknodDevice(target, d)
+		}
+		if err != nil {
+			return fmt.Errorf("create device %s error: %v", d.Path, err)
+		}
+	}
+	return nil
+}
+
+func mknodDevice(target string, d *Device) error {
+	mode := uint32(d.FileMode.Perm())
+	switch d.Type {
+	case "c":
+		mode |= unix.S_IFCHR
+	case "b":
+		mode |= unix.S_IFBLK
+	default:
+		return fmt.Errorf("invalid device type %q", d.Type)
+	}
+
+	dev := unix.Mkdev(uint32(d.Major), uint32(d.Minor))
+	if err := unix.Mknod(target, mode, int(dev)); err != nil && !os.IsExist(err) {
+		return err
+	}
+	return os.Chown(target, int(d.UID), int(d.GID))
+}
+
+// bindDevice 在宿主机的 /dev 中找到同样编号的设备，bind挂载到容器里
+func bindDevice(target string, d *Device) error {
+	source, err := findHostDevice(d)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(target, os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	f.Close()
+	return syscall.Mount(source, target, "", syscall.MS_BIND, "")
+}
+
+// findHostDevice 默认设备和 --device 的设备一般都在宿主机的同一路径上，找不到时再遍历 /dev
+func findHostDevice(d *Device) (string, error) {
+	match := func(p string) bool {
+		var st unix.Stat_t
+		if err := unix.Stat(p, &st); err != nil {
+			return false
+		}
+		return (st.Mode&unix.S_IFMT == unix.S_IFCHR) == (d.Type == "c") &&
+			int64(unix.Major(uint64(st.Rdev))) == d.Major &&
+			int64(unix.Minor(uint64(st.Rdev))) == d.Minor
+	}
+
+	if match(d.Path) {
+		return d.Path, nil
+	}
+	var found string
+	_ = filepath.Walk("/dev", func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return nil
+		}
+		if info.Mode()&os.ModeDevice != 0 && match(p) {
+			found = p
+			return io.EOF
+		}
+		return nil
+	})
+	if found == "" {
+		return "", fmt.Errorf("device %s:%d:%d not found on host", d.Type, d.Major, d.Minor)
+	}
+	return found, nil
+}
+
+// devSymlinks /dev 下的标准符号链接
+var devSymlinks = [][2]string{
+	{"/proc/self/fd", "/dev/fd"},
+	{"/proc/self/fd/0", "/dev/stdin"},
+	{"/proc/self/fd/1", "/dev/stdout"},
+	{"/proc/self/fd/2", "/dev/stderr"},
+	{"pts/ptmx", "/dev/ptmx"},
+}
+
+// setupDev 在容器根目录root下已经挂载好的 /dev 中创建设备文件和符号链接
+func setupDev(root string, devices []*Device) error {
+	if err := createDevices(root, devices); err != nil {
+		return err
+	}
+
+	for _, link := range devSymlinks {
+		// 链接本身不解析，只解析它所在的目录
+		dir, err := securePath(root, filepath.Dir(link[1]))
+		if err != nil {
+			return err
+		}
+		if err := os.Symlink(link[0], filepath.Join(dir, filepath.Base(link[1]))); err != nil && !os.IsExist(err) {
+			return fmt.Errorf("create symlink %s error: %v", link[1], err)
+		}
+	}
+	return setupConsole(root)
+}
+
+// setupConsole -it 时标准输入是父进程分配的pty，把它bind挂载到 /dev/console
+func setupConsole(root string) error {
+	if _, err := unix.IoctlGetTermios(0, unix.TCGETS); err != nil {
+		return nil
+	}
+	tty, err := os.Readlink("/proc/self/fd/0")
+	if err != nil {
+		return err
+	}
+
+	console, err := securePath(root, "/dev/console")
+	if err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(console, nil, 0600); err != nil {
+		return err
+	}
+	if err := syscall.Mount(tty, console, "", syscall.MS_BIND, ""); err != nil {
+		return fmt.Errorf("bind %s to /dev/console error: %v", tty, err)
+	}
+	return nil
+}
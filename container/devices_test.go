@@ -0,0 +1,49 @@
+package container
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseDevice(t *testing.T) {
+	tests := []struct {
+		spec string
+		path string
+		rule string
+	}{
+		{"/dev/null", "/dev/null", "c 1:3 rwm"},
+		{"/dev/null:/dev/mynull", "/dev/mynull", "c 1:3 rwm"},
+		{"/dev/null:r", "/dev/null", "c 1:3 r"},
+		{"/dev/null:/dev/mynull:rw", "/dev/mynull", "c 1:3 rw"},
+	}
+	for _, tt := range tests {
+		d, err := ParseDevice(tt.spec)
+		if err != nil {
+			t.Errorf("ParseDevice(%q) error: %v", tt.spec, err)
+			continue
+		}
+		if d.Path != tt.path || d.String() != tt.rule {
+			t.Errorf("ParseDevice(%q) = %s %q, want %s %q", tt.spec, d.Path, d.String(), tt.path, tt.rule)
+		}
+	}
+
+	for _, spec := range []string{"", "/dev/null:dev/null", "/dev/null:/dev/x:rwx", "/dev/null:/a:b:c", "/etc/passwd"} {
+		if _, err := ParseDevice(spec); err == nil {
+			t.Errorf("ParseDevice(%q) expected error", spec)
+		}
+	}
+}
+
+func TestCgroupDeviceRules(t *testing.T) {
+	if rules := CgroupDeviceRules(DefaultDevices, true); !reflect.DeepEqual(rules, []string{"a *:* rwm"}) {
+		t.Errorf("privileged rules = %v", rules)
+	}
+
+	rules := CgroupDeviceRules(DefaultDevices, false)
+	if len(rules) != len(defaultDeviceRules)+len(DefaultDevices) {
+		t.Errorf("got %d rules, want %d", len(rules), len(defaultDeviceRules)+len(DefaultDevices))
+	}
+	if rules[len(rules)-1] != "c 5:0 rwm" {
+		t.Errorf("last rule = %q, want /dev/tty", rules[len(rules)-1])
+	}
+}
@@ -0,0 +1,93 @@
+package container
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// ParseEnvFile 解析 --env-file 指定的文件，每行一个 KEY=VAL，返回 KEY=VAL 的列表。
+// 只有 KEY 没有等号的行使用宿主机上的同名环境变量，宿主机上没有时忽略，和docker一致
+func ParseEnvFile(file string) ([]string, error) {
+	var envs []string
+	err := scanKeyValueFile(file, func(key, value string, hasValue bool) {
+		if !hasValue {
+			v, ok := os.LookupEnv(key)
+			if !ok {
+				return
+			}
+			value = v
+		}
+		envs = append(envs, key+"="+value)
+	})
+	return envs, err
+}
+
+// ParseLabels 解析 --label-file 和 --label 指定的标签，--label 覆盖文件中的同名标签。
+// 只有 key 没有等号时标签的值为空
+func ParseLabels(labels, labelFiles []string) (map[string]string, error) {
+	result := make(map[string]string)
+	for _, file := range labelFiles {
+		err := scanKeyValueFile(file, func(key, value string, _ bool) {
+			result[key] = value
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	for _, label := range labels {
+		kv := strings.SplitN(label, "=", 2)
+		if kv[0] == "" {
+			return nil, fmt.Errorf("invalid label %q, expect key=value", label)
+		}
+		if len(kv) == 1 {
+			result[kv[0]] = ""
+		} else {
+			result[kv[0]] = kv[1]
+		}
+	}
+	if len(result) == 0 {
+		return nil, nil
+	}
+	return result, nil
+}
+
+// scanKeyValueFile 按行读取 KEY=VAL 格式的文件，跳过空行和 # 开头的注释，
+// 允许行首有 export，值两边成对的单引号或双引号会被去掉
+func scanKeyValueFile(file string, fn func(key, value string, hasValue bool)) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for lineNum := 1; scanner.Scan(); lineNum++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		line = strings.TrimSpace(strings.TrimPrefix(line, "export "))
+
+		kv := strings.SplitN(line, "=", 2)
+		key := strings.TrimSpace(kv[0])
+		if key == "" || strings.ContainsAny(key, " \t") {
+			return fmt.Errorf("invalid line %d in %s: %q", lineNum, file, scanner.Text())
+		}
+		if len(kv) == 1 {
+			fn(key, "", false)
+			continue
+		}
+		fn(key, unquote(kv[1]), true)
+	}
+	return scanner.Err()
+}
+
+// unquote 去掉值两边成对的引号，引号里的内容原样保留
+func unquote(s string) string {
+	if len(s) >= 2 && (s[0] == '"' || s[0] == '\'') && s[len(s)-1] == s[0] {
+		return s[1 : len(s)-1]
+	}
+	return s
+}
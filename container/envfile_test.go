@@ -0,0 +1,81 @@
+package container
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"reflect"
+	"testing"
+)
+
+func writeTempFile(t *testing.T, dir, name, content string) string {
+	file := path.Join(dir, name)
+	if err := ioutil.WriteFile(file, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return file
+}
+
+func TestParseEnvFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ddocker-env")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	os.Setenv("DDOCKER_TEST_HOST", "from-host")
+	defer os.Unsetenv("DDOCKER_TEST_HOST")
+
+	file := writeTempFile(t, dir, "env", `# comment
+A=1
+
+  export B="two words"
+C='it''s'
+D=x=y
+E=
+DDOCKER_TEST_HOST
+DDOCKER_TEST_MISSING
+F="unbalanced
+`)
+	got, err := ParseEnvFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"A=1", "B=two words", "C=it''s", "D=x=y", "E=", "DDOCKER_TEST_HOST=from-host", `F="unbalanced`}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ParseEnvFile = %q, want %q", got, want)
+	}
+
+	bad := writeTempFile(t, dir, "bad", "A B=1\n")
+	if _, err := ParseEnvFile(bad); err == nil {
+		t.Error("ParseEnvFile with space in key should fail")
+	}
+	if _, err := ParseEnvFile(path.Join(dir, "missing")); err == nil {
+		t.Error("ParseEnvFile of a missing file should fail")
+	}
+}
+
+func TestParseLabels(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ddocker-label")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	file := writeTempFile(t, dir, "labels", "app=web\n# comment\nenv=\"dev\"\nflag\n")
+	got, err := ParseLabels([]string{"env=prod", "tier"}, []string{file})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{"app": "web", "env": "prod", "flag": "", "tier": ""}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ParseLabels = %v, want %v", got, want)
+	}
+
+	if got, _ := ParseLabels(nil, nil); got != nil {
+		t.Errorf("ParseLabels without labels = %v, want nil", got)
+	}
+	if _, err := ParseLabels([]string{"=v"}, nil); err == nil {
+		t.Error("ParseLabels with empty key should fail")
+	}
+}
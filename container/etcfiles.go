@@ -0,0 +1,157 @@
+package container
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path"
+	"strings"
+	"syscall"
+)
+
+// 生成的 /etc 下的文件保存在容器状态目录 /var/run/ddocker/${containerID}/ 中，启动时bind挂载到rootfs
+const (
+	HostsFileName      string = "hosts"
+	HostnameFileName   string = "hostname"
+	ResolvConfFileName string = "resolv.conf"
+)
+
+// DefaultDNS 宿主机上没有容器可以访问的DNS服务器时使用，和docker一致
+var DefaultDNS = []string{"8.8.8.8", "8.8.4.4"}
+
+// hostResolvConf 宿主机的resolv.conf，生成容器的resolv.conf时以它为模板
+var hostResolvConf = "/etc/resolv.conf"
+
+// DefaultHostname 容器默认的主机名是容器ID的前12个字符
+func DefaultHostname(containerID string) string {
+	if len(containerID) > 12 {
+		return containerID[:12]
+	}
+	return containerID
+}
+
+// ParseExtraHost 解析 --add-host 的参数 host:ip，IPv6地址中也有冒号，所以只按第一个冒号分隔
+func ParseExtraHost(spec string) (host string, ip string, err error) {
+	i := strings.Index(spec, ":")
+	if i <= 0 {
+		return "", "", fmt.Errorf("invalid extra host %q, expect host:ip", spec)
+	}
+	host, ip = spec[:i], spec[i+1:]
+	if net.ParseIP(ip) == nil {
+		return "", "", fmt.Errorf("invalid ip %q in extra host %q", ip, spec)
+	}
+	return host, ip, nil
+}
+
+// WriteHostnameFile 生成容器的 /etc/hostname
+func WriteHostnameFile(containerID, hostname string) error {
+	return writeEtcFile(containerID, HostnameFileName, []byte(hostname+"\n"))
+}
+
+// WriteHostsFile 生成容器的 /etc/hosts，ip是容器的地址，为空时主机名解析到 127.0.1.1。
+// extraHosts 是 --add-host 指定的 host:ip
+func WriteHostsFile(containerID, hostname, ip string, extraHosts []string) error {
+	var buf bytes.Buffer
+	buf.WriteString("127.0.0.1\tlocalhost\n")
+	buf.WriteString("::1\tlocalhost ip6-localhost ip6-loopback\n")
+	buf.WriteString("fe00::0\tip6-localnet\n")
+	buf.WriteString("ff00::0\tip6-mcastprefix\n")
+	buf.WriteString("ff02::1\tip6-allnodes\n")
+	buf.WriteString("ff02::2\tip6-allrouters\n")
+
+	for _, spec := range extraHosts {
+		host, hostIP, err := ParseExtraHost(spec)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(&buf, "%s\t%s\n", hostIP, host)
+	}
+
+	if ip == "" {
+		ip = "127.0.1.1"
+	}
+	fmt.Fprintf(&buf, "%s\t%s\n", ip, hostname)
+	return writeEtcFile(containerID, HostsFileName, buf.Bytes())
+}
+
+// WriteResolvConf 以宿主机的resolv.conf为模板生成容器的 /etc/resolv.conf。
+// nameservers不为空时替换掉宿主机的DNS服务器，否则去掉宿主机上的本地地址，容器的network namespace中
+// 访问不到它们，去掉之后没有剩下的DNS服务器时使用 DefaultDNS。search不为空时替换掉宿主机的搜索域
+func WriteResolvConf(containerID string, nameservers, search []string) error {
+	var hostNameservers, lines []string
+	hostSearch := ""
+	if f, err := os.Open(hostResolvConf); err == nil {
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			line := scanner.Text()
+			fields := strings.Fields(line)
+			if len(fields) == 0 {
+				lines = append(lines, line)
+				continue
+			}
+			switch fields[0] {
+			case "nameserver":
+				if len(fields) > 1 {
+					if ip := net.ParseIP(fields[1]); ip != nil && !ip.IsLoopback() {
+						hostNameservers = append(hostNameservers, fields[1])
+					}
+				}
+			case "search", "domain":
+				// 以最后一个为准，和resolver的行为一致
+				hostSearch = strings.Join(fields[1:], " ")
+			default:
+				lines = append(lines, line)
+			}
+		}
+		f.Close()
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+
+	if len(nameservers) == 0 {
+		nameservers = hostNameservers
+	}
+	if len(nameservers) == 0 {
+		nameservers = DefaultDNS
+	}
+	if len(search) > 0 {
+		hostSearch = strings.Join(search, " ")
+	}
+
+	var buf bytes.Buffer
+	for _, line := range lines {
+		buf.WriteString(line + "\n")
+	}
+	if hostSearch != "" {
+		fmt.Fprintf(&buf, "search %s\n", hostSearch)
+	}
+	for _, ns := range nameservers {
+		fmt.Fprintf(&buf, "nameserver %s\n", ns)
+	}
+	return writeEtcFile(containerID, ResolvConfFileName, buf.Bytes())
+}
+
+func writeEtcFile(containerID, name string, content []byte) error {
+	file := path.Join(DefaultInfoLocation, containerID, name)
+	if err := ioutil.WriteFile(file, content, 0644); err != nil {
+		return fmt.Errorf("write %s error: %v", file, err)
+	}
+	return nil
+}
+
+// EtcMounts 把容器状态目录中生成的文件bind挂载到容器的 /etc 下
+func EtcMounts(containerID string) []Mount {
+	var mounts []Mount
+	for _, name := range []string{HostsFileName, HostnameFileName, ResolvConfFileName} {
+		mounts = append(mounts, Mount{
+			Source: path.Join(DefaultInfoLocation, containerID, name),
+			Target: path.Join("/etc", name),
+			Type:   "bind",
+			Flags:  syscall.MS_BIND,
+		})
+	}
+	return mounts
+}
@@ -0,0 +1,69 @@
+package container
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+)
+
+func TestWriteResolvConf(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ddocker-etc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	oldLocation, oldResolvConf := DefaultInfoLocation, hostResolvConf
+	defer func() { DefaultInfoLocation, hostResolvConf = oldLocation, oldResolvConf }()
+	DefaultInfoLocation = dir
+	hostResolvConf = path.Join(dir, "host-resolv.conf")
+	if err := os.Mkdir(path.Join(dir, "c1"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		host        string
+		nameservers []string
+		search      []string
+		want        string
+	}{
+		{
+			host: "domain a.com\nnameserver 127.0.0.53\nnameserver 10.0.0.1\noptions ndots:2\n",
+			want: "options ndots:2\nsearch a.com\nnameserver 10.0.0.1\n",
+		},
+		{
+			host: "nameserver 127.0.0.53\n",
+			want: "nameserver 8.8.8.8\nnameserver 8.8.4.4\n",
+		},
+		{
+			host:        "search a.com\nnameserver 10.0.0.1\n",
+			nameservers: []string{"1.1.1.1"},
+			search:      []string{"b.com", "c.com"},
+			want:        "search b.com c.com\nnameserver 1.1.1.1\n",
+		},
+	}
+	for _, tt := range tests {
+		if err := ioutil.WriteFile(hostResolvConf, []byte(tt.host), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := WriteResolvConf("c1", tt.nameservers, tt.search); err != nil {
+			t.Fatal(err)
+		}
+		got, _ := ioutil.ReadFile(path.Join(dir, "c1", ResolvConfFileName))
+		if string(got) != tt.want {
+			t.Errorf("resolv.conf from %q = %q, want %q", tt.host, got, tt.want)
+		}
+	}
+}
+
+func TestParseExtraHost(t *testing.T) {
+	if host, ip, err := ParseExtraHost("v6:fe80::1"); err != nil || host != "v6" || ip != "fe80::1" {
+		t.Errorf("got %s %s %v", host, ip, err)
+	}
+	for _, spec := range []string{"db", ":1.2.3.4", "db:nope"} {
+		if _, _, err := ParseExtraHost(spec); err == nil {
+			t.Errorf("ParseExtraHost(%q) expected error", spec)
+		}
+	}
+}
@@ -6,8 +6,6 @@ import (
 	"os"
 	"os/exec"
 	"path"
-	"strconv"
-	"strings"
 	"syscall"
 	"time"
 
@@ -16,14 +14,34 @@ import (
 
 // ContainerInfo .
 type ContainerInfo struct {
-	PID         string   `json:"pid"`         // 容器的 init 进程在宿主机上的 PID
-	ID          string   `json:"id"`          // 容器 ID
-	Name        string   `json:"name"`        // 容器名
-	Command     string   `json:"command"`     // 容器内 init 运行命令
-	CreatedTime string   `json:"create_time"` // 创建时间
-	Status      string   `json:"status"`      // 容器的状态
-	Volume      string   `json:"volume"`      // 容器的数据卷
-	PortMapping []string `json:"portmapping"` // 容器的端口映射
+	PID             string            `json:"pid"`                         // 容器的 init 进程在宿主机上的 PID
+	ID              string            `json:"id"`                          // 容器 ID
+	Name            string            `json:"name"`                        // 容器名
+	Command         string            `json:"command"`                     // 容器内 init 运行命令
+	CreatedTime     string            `json:"create_time"`                 // 创建时间
+	Status          string            `json:"status"`                      // 容器的状态
+	Volume          string            `json:"volume"`                      // 容器的数据卷
+	PortMapping     []string          `json:"portmapping"`                 // 容器的端口映射
+	Network         string            `json:"network"`                     // 容器连接的网络名
+	IPAddress       string            `json:"ip"`                          // 容器在网络中分配到的IP
+	AutoRemove      bool              `json:"auto_remove"`                 // 容器退出后是否自动清理(--rm)
+	TTY             bool              `json:"tty"`                         // 容器是否分配了终端(-it)
+	Image           string            `json:"image"`                       // 容器使用的镜像
+	LogDriver       string            `json:"log_driver"`                  // 容器的日志驱动，为空表示json-file
+	IDMapping       *IDMapping        `json:"id_mapping,omitempty"`        // 容器user namespace的id映射，为空表示没有使用user namespace
+	Capabilities    []string          `json:"capabilities"`                // 容器进程保留的capability
+	SecurityOpt     []string          `json:"security_opt,omitempty"`      // 创建容器时指定的 --security-opt
+	Seccomp         *Seccomp          `json:"seccomp,omitempty"`           // 容器进程的seccomp配置，exec进去的进程使用同样的配置
+	NoNewPrivileges bool              `json:"no_new_privileges,omitempty"` // 容器进程设置了no_new_privs，exec进去的进程也要设置
+	ReadonlyRootfs  bool              `json:"readonly_rootfs,omitempty"`   // 根目录是否只读(--read-only)
+	Hostname        string            `json:"hostname"`                    // 容器的主机名
+	DNS             []string          `json:"dns,omitempty"`               // --dns 指定的DNS服务器
+	DNSSearch       []string          `json:"dns_search,omitempty"`        // --dns-search 指定的搜索域
+	ExtraHosts      []string          `json:"extra_hosts,omitempty"`       // --add-host 添加到 /etc/hosts 的 host:ip
+	Workdir         string            `json:"workdir,omitempty"`           // 用户命令的工作目录，exec进去的进程默认也在这个目录
+	User            string            `json:"user,omitempty"`              // 运行用户命令的用户(--user)
+	Ulimits         []*Ulimit         `json:"ulimits,omitempty"`           // 容器进程的资源限制，exec进去的进程使用同样的限制
+	Labels          map[string]string `json:"labels,omitempty"`            // --label 和 --label-file 指定的标签，ps --filter label=... 按标签过滤
 }
 
 const (
@@ -32,12 +50,55 @@ const (
 	StatusExit    string = "exit"
 )
 
+// DefaultInfoLocation 容器状态信息的目录，rootless模式下在 $XDG_RUNTIME_DIR/ddocker/
+var DefaultInfoLocation = "/var/run/ddocker/"
+
 const (
-	DefaultInfoLocation string = "/var/run/ddocker/"
-	ConfigName          string = "config.json"
-	StdLogFileName      string = "std.log"
+	ConfigName       string = "config.json"
+	StdLogFileName   string = "std.log"
+	AttachSocketName string = "attach.sock"
 )
 
+// InitConfig 父进程通过管道传给容器init进程的配置
+type InitConfig struct {
+	// 用户命令的参数列表
+	Args []string `json:"args"`
+	// init进程在pivot_root之前要挂载的文件系统，Target是相对容器根目录的路径。
+	// rootless模式下宿主机上不能挂载overlay，rootfs和数据卷都由init进程挂载，
+	// 排在 /proc、/dev、/sys 等容器默认的挂载之前
+	Mounts []Mount `json:"mounts,omitempty"`
+	// 容器的主机名，在容器的UTS namespace中设置
+	Hostname string `json:"hostname,omitempty"`
+	// 用户命令的工作目录，不存在时创建
+	Workdir string `json:"workdir,omitempty"`
+	// 运行用户命令的用户 name|uid[:group|gid]，为空时是root
+	User string `json:"user,omitempty"`
+	// 用户命令的资源限制，包括全局默认配置和 --ulimit 指定的限制
+	Ulimits []*Ulimit `json:"ulimits,omitempty"`
+	// 用户命令保留的capability
+	Capabilities []string `json:"capabilities"`
+	// 用户命令的seccomp配置，为空时不限制系统调用
+	Seccomp *Seccomp `json:"seccomp,omitempty"`
+	// exec之前设置no_new_privs，setuid程序和文件capability不能再提升权限
+	NoNewPrivileges bool `json:"no_new_privileges,omitempty"`
+	// 根目录挂载成只读
+	ReadonlyRootfs bool `json:"readonly_rootfs,omitempty"`
+	// 屏蔽的路径和只读的路径，都是容器内的绝对路径
+	MaskedPaths   []string `json:"masked_paths,omitempty"`
+	ReadonlyPaths []string `json:"readonly_paths,omitempty"`
+	// /dev 下创建的设备文件，包括默认设备和 --device 指定的设备
+	Devices []*Device `json:"devices,omitempty"`
+}
+
+// Mount 对应一次mount系统调用
+type Mount struct {
+	Source string  `json:"source"`
+	Target string  `json:"target"`
+	Type   string  `json:"type"`
+	Flags  uintptr `json:"flags"`
+	Data   string  `json:"data"`
+}
+
 // NewParentProcess 这里是父进程（当前进程执行的内容）
 // 1. 在/proc/self/exe的调用中, /proc/self/指的就是当前进程自己的环境,
 // exec其实就是自己调用了自己。init和command参数是传递给本进程的。
@@ -45,8 +106,11 @@ const (
 // 先调用init, 即调用initCommand去执行一些环境和资源的初始化操作。
 //
 // 3. 下面指定了一些clone参数去fork新进程，并使用namespace隔离新创建的进程和外部环境。
-// 4. 如果用指定了-it参数，就需要把进程的输入输出导入到标准的输入输出
-func NewParentProcess(tty bool, cid, volume, image string, envs []string) (*exec.Cmd, *os.File) {
+// 4. 指定了idmap时再创建user namespace，容器内的root映射到宿主机上的普通用户。
+// 需要init进程挂载的文件系统记录到initConfig中，由调用者连同用户命令一起发送给init进程。
+// 5. 如果用指定了-it参数，就给容器分配一个pty，slave端作为容器的控制终端；
+// 否则用管道连接容器的标准输入输出。宿主机一侧的输入输出返回给调用者(监控进程)持有
+func NewParentProcess(tty bool, cid, volume, image string, envs []string, idmap *IDMapping, initConfig *InitConfig) (*exec.Cmd, *os.File, *ContainerIO) {
 	readPipe, writePipe, err := NewPipe()
 	if err != nil {
 		logrus.Errorf("New pipe error: %v", err)
@@ -59,26 +123,55 @@ func NewParentProcess(tty bool, cid, volume, image string, envs []string) (*exec
 			syscall.CLONE_NEWPID | syscall.CLONE_NEWNS | syscall.CLONE_NEWNET,
 		Unshareflags: syscall.CLONE_NEWNS,
 	}
+	if idmap != nil {
+		cmd.SysProcAttr.Cloneflags |= syscall.CLONE_NEWUSER
+		cmd.SysProcAttr.UidMappings = sysProcIDMaps(idmap.UIDMaps)
+		cmd.SysProcAttr.GidMappings = sysProcIDMaps(idmap.GIDMaps)
+		// 创建user namespace不会改变进程的uid，需要切换成容器内的root，也就是宿主机上映射的uid
+		cmd.SysProcAttr.Credential = &syscall.Credential{Uid: 0, Gid: 0}
+		if Rootless() {
+			// 普通用户写gid_map之前必须禁止setgroups
+			cmd.SysProcAttr.Credential.NoSetGroups = true
+		} else {
+			// 允许容器内调用setgroups，exec -u 切换用户时需要
+			cmd.SysProcAttr.GidMappingsEnableSetgroups = true
+		}
+	}
+
+	cio, err := newContainerIO(tty)
+	if err != nil {
+		logrus.Errorf("New container io error: %v", err)
+		return nil, nil, nil
+	}
+	cmd.Stdin = cio.childFiles[0]
+	cmd.Stdout = cio.childFiles[1]
+	cmd.Stderr = cio.childFiles[2]
 
 	if tty {
-		cmd.Stdin = os.Stdin
-		cmd.Stdout = os.Stdout
-		cmd.Stderr = os.Stderr
-	} else {
-		// /var/run/ddocker/${containerID}/std.log
-		stdLogFile := RedirectContainerLog(cid)
-		if stdLogFile == nil {
-			return nil, nil
-		}
-		cmd.Stdout = stdLogFile
+		// 容器进程成为新会话的leader，并把slave端(fd 0)设置为控制终端
+		cmd.SysProcAttr.Setsid = true
+		cmd.SysProcAttr.Setctty = true
+		cmd.SysProcAttr.Ctty = 0
 	}
 
 	cmd.ExtraFiles = []*os.File{readPipe}   // 传入管道读取端的句柄
 	cmd.Env = append(os.Environ(), envs...) // 继承父进程的环境变量
 
-	NewWorkSpace(cid, volume, image)
-	cmd.Dir = fmt.Sprintf(MntURL, cid)
-	return cmd, writePipe
+	initConfig.Mounts = append(NewWorkSpace(cid, volume, image, idmap), initConfig.Mounts...)
+	cmd.Dir = MountPoint(cid, idmap)
+	return cmd, writePipe, cio
+}
+
+// SendInitConfig 把InitConfig写入管道后关闭管道，init进程读到EOF后开始执行
+func SendInitConfig(writePipe *os.File, config *InitConfig) error {
+	defer writePipe.Close()
+
+	b, err := json.Marshal(config)
+	if err != nil {
+		return err
+	}
+	_, err = writePipe.Write(b)
+	return err
 }
 
 // NewPipe .
@@ -90,49 +183,39 @@ func NewPipe() (*os.File, *os.File, error) {
 	return read, write, nil
 }
 
-// RecordContainerInfo
-func RecordContainerInfo(cpid int, commandArr []string, id, name, volume string) (string, error) {
-	createTime := time.Now().Format("2006-01-02 15:04:05")
-	command := strings.Join(commandArr, " ")
-
-	if name == "" {
-		name = id
-	}
-
-	info := &ContainerInfo{
-		ID:          id,
-		PID:         strconv.Itoa(cpid),
-		Name:        name,
-		Command:     command,
-		CreatedTime: createTime,
-		Status:      StatusRunning,
-		Volume:      volume,
+// RecordContainerInfo 补全创建时间、状态等信息后，
+// 将容器信息保存到 /var/run/ddocker/${containerID}/config.json
+func RecordContainerInfo(info *ContainerInfo) error {
+	info.CreatedTime = time.Now().Format("2006-01-02 15:04:05")
+	info.Status = StatusRunning
+	if info.Name == "" {
+		info.Name = info.ID
 	}
 
 	b, err := json.Marshal(info)
 	if err != nil {
-		return "", fmt.Errorf("marshal container info error[%v]", err)
+		return fmt.Errorf("marshal container info error[%v]", err)
 	}
 
 	// /var/run/ddocker/${containerID}/
-	folder := path.Join(DefaultInfoLocation, id)
-	if err := os.MkdirAll(folder, 0622); err != nil {
-		return "", err
+	folder := path.Join(DefaultInfoLocation, info.ID)
+	if err := os.MkdirAll(folder, 0755); err != nil {
+		return err
 	}
 
 	// /var/run/ddocker/${containerID}/config.json
 	dstFile := path.Join(folder, ConfigName)
 	f, err := os.Create(dstFile)
 	if err != nil {
-		return "", err
+		return err
 	}
 	defer f.Close()
 
 	if _, err := f.WriteString(string(b)); err != nil {
-		return "", fmt.Errorf("write container info error[%v]", err)
+		return fmt.Errorf("write container info error[%v]", err)
 	}
 
-	return id, nil
+	return nil
 }
 
 // DeleteContainerInfo
@@ -143,23 +226,3 @@ func DeleteContainerInfo(containerID string) {
 		logrus.Errorf("func[DeleteContainerInfo] error: %v", err)
 	}
 }
-
-// RedirectContainerLog
-func RedirectContainerLog(containerID string) *os.File {
-	// /var/run/ddocker/${containerID}
-	dir := path.Join(DefaultInfoLocation, containerID)
-	if err := os.MkdirAll(dir, 0622); err != nil {
-		logrus.Errorf("func[RedirectContainerLog] error[%v]", err)
-		return nil
-	}
-
-	// /var/run/ddocker/${containerID}/std.log
-	stdLogFilePath := path.Join(dir, StdLogFileName)
-	stdLogFile, err := os.Create(stdLogFilePath)
-	if err != nil {
-		logrus.Errorf("func[RedirectContainerLog] error[%v]", err)
-		return nil
-	}
-
-	return stdLogFile
-}
@@ -0,0 +1,136 @@
+package container
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"syscall"
+)
+
+// DefaultShmSize /dev/shm 默认的大小，和docker一致是64MB
+const DefaultShmSize int64 = 64 << 20
+
+// DefaultMounts 容器内默认挂载的文件系统，在rootfs和数据卷之后按顺序挂载，Target是容器内的绝对路径。
+// user namespace中挂载proc和sysfs时，要求当前mount namespace中还有一个完整可见的同类文件系统，
+// 所以这些挂载都在pivot_root卸载宿主机的根目录之前完成。--privileged 的容器里 /sys 是可写的
+func DefaultMounts(shmSize int64, privileged bool) []Mount {
+	sysFlags := uintptr(syscall.MS_NOSUID | syscall.MS_NOEXEC | syscall.MS_NODEV)
+	if !privileged {
+		sysFlags |= syscall.MS_RDONLY
+	}
+
+	return []Mount{
+		{
+			Source: "proc",
+			Target: "/proc",
+			Type:   "proc",
+			Flags:  syscall.MS_NOSUID | syscall.MS_NOEXEC | syscall.MS_NODEV,
+		},
+		{
+			Source: "tmpfs",
+			Target: "/dev",
+			Type:   "tmpfs",
+			Flags:  syscall.MS_NOSUID | syscall.MS_STRICTATIME,
+			Data:   "mode=755,size=65536k",
+		},
+		{
+			// newinstance 使容器有自己的pty编号，看不到宿主机上的终端
+			Source: "devpts",
+			Target: "/dev/pts",
+			Type:   "devpts",
+			Flags:  syscall.MS_NOSUID | syscall.MS_NOEXEC,
+			Data:   "newinstance,ptmxmode=0666,mode=0620,gid=5",
+		},
+		{
+			Source: "shm",
+			Target: "/dev/shm",
+			Type:   "tmpfs",
+			Flags:  syscall.MS_NOSUID | syscall.MS_NOEXEC | syscall.MS_NODEV,
+			Data:   fmt.Sprintf("mode=1777,size=%d", shmSize),
+		},
+		{
+			Source: "mqueue",
+			Target: "/dev/mqueue",
+			Type:   "mqueue",
+			Flags:  syscall.MS_NOSUID | syscall.MS_NOEXEC | syscall.MS_NODEV,
+		},
+		{
+			Source: "sysfs",
+			Target: "/sys",
+			Type:   "sysfs",
+			Flags:  sysFlags,
+		},
+	}
+}
+
+// MergeMounts 用户指定的挂载和默认挂载的目标路径相同时替换掉默认挂载，其余的追加在后面
+func MergeMounts(defaults, mounts []Mount) []Mount {
+	merged := append([]Mount{}, defaults...)
+	for _, m := range mounts {
+		replaced := false
+		for i := range merged {
+			if filepath.Clean(merged[i].Target) == filepath.Clean(m.Target) {
+				merged[i] = m
+				replaced = true
+				break
+			}
+		}
+		if !replaced {
+			merged = append(merged, m)
+		}
+	}
+	return merged
+}
+
+// tmpfsFlags --tmpfs 中对应mount标志的选项，其余选项原样传给tmpfs
+var tmpfsFlags = map[string]struct {
+	clear bool
+	flag  uintptr
+}{
+	"ro":          {false, syscall.MS_RDONLY},
+	"rw":          {true, syscall.MS_RDONLY},
+	"nosuid":      {false, syscall.MS_NOSUID},
+	"suid":        {true, syscall.MS_NOSUID},
+	"nodev":       {false, syscall.MS_NODEV},
+	"dev":         {true, syscall.MS_NODEV},
+	"noexec":      {false, syscall.MS_NOEXEC},
+	"exec":        {true, syscall.MS_NOEXEC},
+	"noatime":     {false, syscall.MS_NOATIME},
+	"strictatime": {false, syscall.MS_STRICTATIME},
+}
+
+// ParseTmpfs 解析 --tmpfs 的参数 path[:options]，例如 /run:rw,noexec,size=64m。
+// 和docker一样默认带上 noexec、nosuid、nodev
+func ParseTmpfs(spec string) (Mount, error) {
+	target, options := spec, ""
+	if i := strings.Index(spec, ":"); i >= 0 {
+		target, options = spec[:i], spec[i+1:]
+	}
+	if !filepath.IsAbs(target) {
+		return Mount{}, fmt.Errorf("invalid tmpfs %q, path must be absolute", spec)
+	}
+
+	m := Mount{
+		Source: "tmpfs",
+		Target: filepath.Clean(target),
+		Type:   "tmpfs",
+		Flags:  syscall.MS_NOEXEC | syscall.MS_NOSUID | syscall.MS_NODEV,
+	}
+	var data []string
+	for _, o := range strings.Split(options, ",") {
+		if o == "" {
+			continue
+		}
+		if f, ok := tmpfsFlags[o]; ok {
+			if f.clear {
+				m.Flags &^= f.flag
+			} else {
+				m.Flags |= f.flag
+			}
+			continue
+		}
+		data = append(data, o)
+	}
+	m.Data = strings.Join(data, ",")
+	return m, nil
+}
@@ -0,0 +1,111 @@
+package container
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+)
+
+func TestParseTmpfs(t *testing.T) {
+	m, err := ParseTmpfs("/run:rw,exec,size=64m,mode=1777")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Target != "/run" || m.Type != "tmpfs" || m.Data != "size=64m,mode=1777" {
+		t.Errorf("got %+v", m)
+	}
+	if want := uintptr(syscall.MS_NOSUID | syscall.MS_NODEV); m.Flags != want {
+		t.Errorf("flags = %#x, want %#x", m.Flags, want)
+	}
+
+	if _, err := ParseTmpfs("run"); err == nil {
+		t.Errorf("expected error for relative path")
+	}
+}
+
+func TestMergeMounts(t *testing.T) {
+	shm, _ := ParseTmpfs("/dev/shm/:size=1g")
+	run, _ := ParseTmpfs("/run")
+	defaults := DefaultMounts(DefaultShmSize, false)
+	mounts := MergeMounts(defaults, []Mount{shm, run})
+
+	if len(mounts) != len(defaults)+1 {
+		t.Fatalf("got %d mounts, want %d", len(mounts), len(defaults)+1)
+	}
+	for i, m := range mounts {
+		if m.Target == "/dev/shm" && m.Data != "size=1g" {
+			t.Errorf("/dev/shm not replaced: %+v", m)
+		}
+		if i < len(defaults) && m.Target != defaults[i].Target {
+			t.Errorf("mount %d target = %s, want %s", i, m.Target, defaults[i].Target)
+		}
+	}
+	if mounts[len(mounts)-1].Target != "/run" {
+		t.Errorf("last mount = %s, want /run", mounts[len(mounts)-1].Target)
+	}
+}
+
+func TestMountAllStopsAtFirstError(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ddocker-mount")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	mounts := []Mount{
+		{Source: "tmpfs", Target: "/run", Type: "tmpfs", Data: "size=abc"},
+		{Source: "tmpfs", Target: "/tmp", Type: "tmpfs"},
+	}
+	if err := mountAll(dir, mounts); err == nil {
+		syscall.Unmount(filepath.Join(dir, "run"), 0)
+		t.Fatal("mountAll with invalid tmpfs size should fail")
+	}
+	if _, err := os.Stat(filepath.Join(dir, "tmp")); !os.IsNotExist(err) {
+		t.Errorf("mountAll continued after the first error: %v", err)
+	}
+}
+
+func TestMountAllInRoot(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("mount needs root")
+	}
+
+	root, err := ioutil.TempDir("", "ddocker-mount")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	outside, err := ioutil.TempDir("", "ddocker-outside")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(outside)
+
+	// 镜像中的 /etc 指向宿主机上的目录，/run 是指向 /etc 的相对链接
+	source := filepath.Join(root, "hosts.src")
+	ioutil.WriteFile(source, []byte("127.0.0.1 localhost\n"), 0644)
+	os.Symlink(outside, filepath.Join(root, "etc"))
+	os.Symlink("etc", filepath.Join(root, "run"))
+
+	inMountNS(t, func() error {
+		mounts := []Mount{
+			{Source: source, Target: "/etc/hosts", Flags: syscall.MS_BIND},
+			{Source: "tmpfs", Target: "/run/tmp", Type: "tmpfs"},
+		}
+		if err := mountAll(root, mounts); err != nil {
+			return err
+		}
+		for _, name := range []string{"hosts", "tmp"} {
+			if _, err := os.Lstat(filepath.Join(outside, name)); !os.IsNotExist(err) {
+				t.Errorf("%s created outside the rootfs: %v", name, err)
+			}
+		}
+		b, err := ioutil.ReadFile(filepath.Join(root, outside, "hosts"))
+		if err != nil || string(b) != "127.0.0.1 localhost\n" {
+			t.Errorf("hosts inside the rootfs = %q, %v", b, err)
+		}
+		return nil
+	})
+}
@@ -0,0 +1,174 @@
+package container
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// DefaultMaskedPaths 容器内默认屏蔽的路径，和docker的默认列表一致。
+// 文件上bind挂载 /dev/null，目录上挂载一个只读的空tmpfs
+var DefaultMaskedPaths = []string{
+	"/proc/asound",
+	"/proc/acpi",
+	"/proc/kcore",
+	"/proc/keys",
+	"/proc/latency_stats",
+	"/proc/timer_list",
+	"/proc/timer_stats",
+	"/proc/sched_debug",
+	"/proc/scsi",
+	"/sys/firmware",
+	"/sys/devices/virtual/powercap",
+}
+
+// DefaultReadonlyPaths 容器内默认只读的路径
+var DefaultReadonlyPaths = []string{
+	"/proc/bus",
+	"/proc/fs",
+	"/proc/irq",
+	"/proc/sys",
+	"/proc/sysrq-trigger",
+}
+
+// maskPath 屏蔽容器根目录root下的path，path不存在时跳过。
+// 在pivot_root之前调用，这时还可以使用宿主机的 /dev/null
+func maskPath(root, path string) error {
+	target, err := securePath(root, path)
+	if err != nil {
+		return err
+	}
+	info, err := os.Stat(target)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+
+	if info.IsDir() {
+		err = syscall.Mount("tmpfs", target, "tmpfs", syscall.MS_RDONLY, "")
+	} else {
+		err = syscall.Mount("/dev/null", target, "", syscall.MS_BIND, "")
+	}
+	if err != nil {
+		return fmt.Errorf("mask %s error: %v", path, err)
+	}
+	return nil
+}
+
+// readonlyPath 把容器根目录root下的path bind挂载到自己上再改成只读，path不存在时跳过
+func readonlyPath(root, path string) error {
+	target, err := securePath(root, path)
+	if err != nil {
+		return err
+	}
+	if _, err := os.Stat(target); err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+
+	if err := syscall.Mount(target, target, "", syscall.MS_BIND|syscall.MS_REC, ""); err != nil {
+		return fmt.Errorf("bind %s error: %v", path, err)
+	}
+	return remountReadonly(target)
+}
+
+// 已有挂载点上的标志，statfs返回的 ST_* 和 mount 使用的 MS_* 不完全相同
+var mountFlagsOfStatfs = map[int64]uintptr{
+	unix.ST_NOSUID:      syscall.MS_NOSUID,
+	unix.ST_NODEV:       syscall.MS_NODEV,
+	unix.ST_NOEXEC:      syscall.MS_NOEXEC,
+	unix.ST_NOATIME:     syscall.MS_NOATIME,
+	unix.ST_NODIRATIME:  syscall.MS_NODIRATIME,
+	unix.ST_RELATIME:    syscall.MS_RELATIME,
+	unix.ST_SYNCHRONOUS: syscall.MS_SYNCHRONOUS,
+	unix.ST_MANDLOCK:    syscall.MS_MANDLOCK,
+}
+
+// remountReadonly 把挂载点重新挂载成只读。user namespace中不能去掉从外面继承来的
+// nosuid、nodev等标志，所以要带上挂载点原有的标志
+func remountReadonly(target string) error {
+	var st unix.Statfs_t
+	if err := unix.Statfs(target, &st); err != nil {
+		return fmt.Errorf("statfs %s error: %v", target, err)
+	}
+
+	flags := syscall.MS_BIND | syscall.MS_REMOUNT | syscall.MS_RDONLY | statfsMountFlags(int64(st.Flags))
+	if err := syscall.Mount("", target, "", flags, ""); err != nil {
+		return fmt.Errorf("remount %s read-only error: %v", target, err)
+	}
+	return nil
+}
+
+// statfsMountFlags 把statfs返回的 ST_* 标志转换成 MS_* 标志，只读标志由调用者决定
+func statfsMountFlags(stFlags int64) uintptr {
+	var flags uintptr
+	for stFlag, msFlag := range mountFlagsOfStatfs {
+		if stFlags&stFlag != 0 {
+			flags |= msFlag
+		}
+	}
+	return flags
+}
+
+// securePath 返回容器根目录root下path的实际路径，path中的符号链接都在root中解析，
+// 镜像中指向绝对路径的符号链接不会让创建文件和挂载落到宿主机上
+func securePath(root, path string) (string, error) {
+	p, err := resolveInRoot(root, path)
+	if err != nil {
+		return "", err
+	}
+	root = filepath.Clean(root)
+	if root != "/" && p != root && !strings.HasPrefix(p, root+"/") {
+		return "", fmt.Errorf("%s resolves outside the rootfs: %s", path, p)
+	}
+	return p, nil
+}
+
+// resolveInRoot 解析容器根目录root下path中的符号链接，绝对路径的链接也相对于root解析，
+// .. 最多回到root。不存在的部分原样保留，之后创建时也不会离开root
+func resolveInRoot(root, path string) (string, error) {
+	resolved := "/"
+	rest := strings.Split(path, "/")
+	for links := 0; len(rest) > 0; {
+		name := rest[0]
+		rest = rest[1:]
+		if name == "" || name == "." {
+			continue
+		}
+		if name == ".." {
+			resolved = filepath.Dir(resolved)
+			continue
+		}
+
+		next := filepath.Join(resolved, name)
+		info, err := os.Lstat(filepath.Join(root, next))
+		if os.IsNotExist(err) || err == nil && info.Mode()&os.ModeSymlink == 0 {
+			resolved = next
+			continue
+		}
+		if err != nil {
+			return "", err
+		}
+
+		if links++; links > 40 {
+			return "", fmt.Errorf("too many levels of symbolic links in %s", path)
+		}
+		target, err := os.Readlink(filepath.Join(root, next))
+		if err != nil {
+			return "", err
+		}
+		if filepath.IsAbs(target) {
+			resolved = "/"
+		}
+		rest = append(strings.Split(target, "/"), rest...)
+	}
+	return filepath.Join(root, resolved), nil
+}
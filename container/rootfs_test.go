@@ -0,0 +1,172 @@
+package container
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"syscall"
+	"testing"
+
+	"golang.org/x/sys/unix"
+)
+
+func TestStatfsMountFlags(t *testing.T) {
+	tests := []struct {
+		st   int64
+		want uintptr
+	}{
+		{0, 0},
+		{unix.ST_RDONLY, 0},
+		{unix.ST_NOSUID | unix.ST_NODEV, syscall.MS_NOSUID | syscall.MS_NODEV},
+		{unix.ST_NOEXEC | unix.ST_RDONLY, syscall.MS_NOEXEC},
+		{unix.ST_NOATIME | unix.ST_NODIRATIME, syscall.MS_NOATIME | syscall.MS_NODIRATIME},
+		// ST_RELATIME 和 MS_RELATIME 的值不同
+		{unix.ST_RELATIME, syscall.MS_RELATIME},
+		{unix.ST_SYNCHRONOUS | unix.ST_MANDLOCK, syscall.MS_SYNCHRONOUS | syscall.MS_MANDLOCK},
+	}
+	for _, tt := range tests {
+		if got := statfsMountFlags(tt.st); got != tt.want {
+			t.Errorf("statfsMountFlags(%#x) = %#x, want %#x", tt.st, got, tt.want)
+		}
+	}
+}
+
+func TestRemountReadonly(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("remount needs root")
+	}
+
+	dir, err := ioutil.TempDir("", "ddocker-rootfs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	inMountNS(t, func() error {
+		return remountInNewNS(t, dir)
+	})
+}
+
+// inMountNS 在单独的线程和mount namespace中执行fn，线程退出后挂载随namespace一起消失
+func inMountNS(t *testing.T, fn func() error) {
+	errc := make(chan error, 1)
+	go func() {
+		runtime.LockOSThread()
+		if err := unix.Unshare(unix.CLONE_NEWNS); err != nil {
+			errc <- err
+			return
+		}
+		if err := unix.Mount("", "/", "", unix.MS_REC|unix.MS_PRIVATE, ""); err != nil {
+			errc <- err
+			return
+		}
+		errc <- fn()
+	}()
+	if err := <-errc; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func remountInNewNS(t *testing.T, dir string) error {
+	if err := unix.Mount("tmpfs", dir, "tmpfs", unix.MS_NOSUID|unix.MS_NODEV|unix.MS_NOEXEC, ""); err != nil {
+		return err
+	}
+
+	if err := remountReadonly(dir); err != nil {
+		return err
+	}
+	var st unix.Statfs_t
+	if err := unix.Statfs(dir, &st); err != nil {
+		return err
+	}
+	want := int64(unix.ST_RDONLY | unix.ST_NOSUID | unix.ST_NODEV | unix.ST_NOEXEC)
+	if int64(st.Flags)&want != want {
+		t.Errorf("flags after remount = %#x, want %#x set", st.Flags, want)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "file"), nil, 0644); !errors.Is(err, syscall.EROFS) {
+		t.Errorf("write to read-only mount: %v", err)
+	}
+
+	if err := remountReadonly(filepath.Join(dir, "missing")); err == nil {
+		t.Error("remount of a missing path should fail")
+	}
+	return nil
+}
+
+func TestSecurePath(t *testing.T) {
+	root, err := ioutil.TempDir("", "ddocker-rootfs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	os.MkdirAll(filepath.Join(root, "etc"), 0755)
+	os.MkdirAll(filepath.Join(root, "srv/data"), 0755)
+	os.Symlink("/etc", filepath.Join(root, "app"))
+	os.Symlink("../../../../srv", filepath.Join(root, "etc/up"))
+	os.Symlink("data", filepath.Join(root, "srv/cur"))
+	os.Symlink("loop", filepath.Join(root, "loop"))
+
+	tests := map[string]string{
+		"/":                      "/",
+		"/etc/hosts":             "/etc/hosts",
+		"/app/hosts":             "/etc/hosts",
+		"/app/up/cur/x":          "/srv/data/x",
+		"/missing/../../../etc":  "/etc",
+		"/missing/a/../../app/x": "/etc/x",
+		"../../tmp":              "/tmp",
+	}
+	for p, want := range tests {
+		got, err := securePath(root, p)
+		if err != nil {
+			t.Errorf("securePath(%q) error: %v", p, err)
+			continue
+		}
+		if want = filepath.Join(root, want); got != want {
+			t.Errorf("securePath(%q) = %q, want %q", p, got, want)
+		}
+	}
+
+	// pivot_root之后以 / 为根目录解析
+	if got, err := securePath("/", "/ddocker-missing/../ddocker-missing2/x"); err != nil || got != "/ddocker-missing2/x" {
+		t.Errorf("securePath(/, /ddocker-missing/../ddocker-missing2/x) = %q, %v", got, err)
+	}
+
+	if _, err := securePath(root, "/loop/x"); err == nil {
+		t.Error("securePath with a symlink loop should fail")
+	}
+}
+
+func TestCreateDevicesInRoot(t *testing.T) {
+	if os.Geteuid() != 0 || runningInUserNS() {
+		t.Skip("mknod needs root")
+	}
+
+	root, err := ioutil.TempDir("", "ddocker-rootfs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	outside, err := ioutil.TempDir("", "ddocker-outside")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(outside)
+
+	// 镜像中的 /dev 指向宿主机上的目录
+	if err := os.Symlink(outside, filepath.Join(root, "dev")); err != nil {
+		t.Fatal(err)
+	}
+	d := &Device{Path: "/dev/mynull", DeviceRule: DeviceRule{Type: "c", Major: 1, Minor: 3}, FileMode: 0666}
+	if err := createDevices(root, []*Device{d}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Lstat(filepath.Join(outside, "mynull")); !os.IsNotExist(err) {
+		t.Errorf("device created outside the rootfs: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(root, outside, "mynull")); err != nil {
+		t.Errorf("device not created inside the rootfs: %v", err)
+	}
+}
@@ -0,0 +1,57 @@
+package container
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// Rootless 是否以普通用户运行。rootless模式下容器运行在只映射了当前用户的user namespace中，
+// overlay和数据卷由容器的init进程在自己的mount namespace中挂载
+func Rootless() bool {
+	return os.Geteuid() != 0
+}
+
+// SetupRootlessPaths 普通用户没有权限写 /root 和 /var/run，rootless模式下
+// 镜像和容器的工作空间放到 $XDG_DATA_HOME/ddocker/，容器状态放到 $XDG_RUNTIME_DIR/ddocker/，
+// 全局配置放到 $XDG_CONFIG_HOME/ddocker/
+func SetupRootlessPaths() {
+	if !Rootless() {
+		return
+	}
+
+	home, err := os.UserHomeDir()
+	if err != nil {
+		home = os.TempDir()
+	}
+	dataHome := os.Getenv("XDG_DATA_HOME")
+	if dataHome == "" {
+		dataHome = filepath.Join(home, ".local", "share")
+	}
+	dataDir := filepath.Join(dataHome, "ddocker")
+
+	configHome := os.Getenv("XDG_CONFIG_HOME")
+	if configHome == "" {
+		configHome = filepath.Join(home, ".config")
+	}
+
+	runtimeDir := os.Getenv("XDG_RUNTIME_DIR")
+	if runtimeDir == "" {
+		runtimeDir = filepath.Join(os.TempDir(), fmt.Sprintf("ddocker-%d", os.Geteuid()))
+	}
+
+	RootURL = dataDir + "/"
+	MntURL = filepath.Join(dataDir, "mnt") + "/%s"
+	WriteLayerURL = filepath.Join(dataDir, "writeLayer") + "/%s"
+	WorkDirURL = filepath.Join(dataDir, "work") + "/%s"
+	DefaultInfoLocation = filepath.Join(runtimeDir, "ddocker") + "/"
+	DaemonConfigPath = filepath.Join(configHome, "ddocker", "daemon.json")
+}
+
+// RootlessIDMapping 普通用户只能把自己的uid和gid映射成容器内的root
+func RootlessIDMapping() *IDMapping {
+	return &IDMapping{
+		UIDMaps: []IDMap{{ContainerID: 0, HostID: os.Geteuid(), Size: 1}},
+		GIDMaps: []IDMap{{ContainerID: 0, HostID: os.Getegid(), Size: 1}},
+	}
+}
@@ -0,0 +1,501 @@
+package container
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"runtime"
+	"strconv"
+	"strings"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// seccomp配置中的动作，和docker、libseccomp的名字一致
+const (
+	ActKill        = "SCMP_ACT_KILL"
+	ActKillProcess = "SCMP_ACT_KILL_PROCESS"
+	ActKillThread  = "SCMP_ACT_KILL_THREAD"
+	ActTrap        = "SCMP_ACT_TRAP"
+	ActErrno       = "SCMP_ACT_ERRNO"
+	ActTrace       = "SCMP_ACT_TRACE"
+	ActLog         = "SCMP_ACT_LOG"
+	ActAllow       = "SCMP_ACT_ALLOW"
+)
+
+// 系统调用参数的比较方式
+const (
+	OpNotEqual     = "SCMP_CMP_NE"
+	OpLessThan     = "SCMP_CMP_LT"
+	OpLessEqual    = "SCMP_CMP_LE"
+	OpEqualTo      = "SCMP_CMP_EQ"
+	OpGreaterEqual = "SCMP_CMP_GE"
+	OpGreaterThan  = "SCMP_CMP_GT"
+	OpMaskedEqual  = "SCMP_CMP_MASKED_EQ"
+)
+
+// seccomp过滤器的返回值，对应 linux/seccomp.h
+const (
+	seccompRetKillProcess = 0x80000000
+	seccompRetKillThread  = 0x00000000
+	seccompRetTrap        = 0x00030000
+	seccompRetErrno       = 0x00050000
+	seccompRetTrace       = 0x7ff00000
+	seccompRetLog         = 0x7ffc0000
+	seccompRetAllow       = 0x7fff0000
+)
+
+// struct seccomp_data 中各字段的偏移
+const (
+	seccompDataNr   = 0
+	seccompDataArch = 4
+	seccompDataArgs = 16
+)
+
+// Seccomp docker格式的seccomp配置文件。Architectures 中本机内核能运行的架构（amd64上的x86、x32，
+// arm64上的arm）按各自的系统调用表生成规则，没有列出的这些架构的系统调用会杀死进程
+type Seccomp struct {
+	DefaultAction   string     `json:"defaultAction"`
+	DefaultErrnoRet *uint      `json:"defaultErrnoRet,omitempty"`
+	Architectures   []string   `json:"architectures,omitempty"`
+	Syscalls        []*Syscall `json:"syscalls"`
+}
+
+// Syscall 一条规则，Names中的系统调用在参数满足Args时执行Action
+type Syscall struct {
+	Name     string   `json:"name,omitempty"`
+	Names    []string `json:"names,omitempty"`
+	Action   string   `json:"action"`
+	ErrnoRet *uint    `json:"errnoRet,omitempty"`
+	Args     []*Arg   `json:"args,omitempty"`
+	Comment  string   `json:"comment,omitempty"`
+	Includes Filter   `json:"includes"`
+	Excludes Filter   `json:"excludes"`
+}
+
+// Filter 规则生效的条件，Includes中的capability都拥有、Excludes中的capability都没有时规则才生效
+type Filter struct {
+	Caps      []string `json:"caps,omitempty"`
+	Arches    []string `json:"arches,omitempty"`
+	MinKernel string   `json:"minKernel,omitempty"`
+}
+
+// Arg 系统调用第Index个参数的比较条件，SCMP_CMP_MASKED_EQ 时Value是掩码，ValueTwo是比较的值
+type Arg struct {
+	Index    uint   `json:"index"`
+	Value    uint64 `json:"value"`
+	ValueTwo uint64 `json:"valueTwo"`
+	Op       string `json:"op"`
+}
+
+// LoadSeccompProfile 读取docker格式的seccomp配置文件
+func LoadSeccompProfile(path string) (*Seccomp, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	profile := &Seccomp{}
+	if err := json.Unmarshal(b, profile); err != nil {
+		return nil, fmt.Errorf("decode seccomp profile %s error: %v", path, err)
+	}
+	if profile.DefaultAction == "" {
+		return nil, fmt.Errorf("seccomp profile %s has no defaultAction", path)
+	}
+	return profile, nil
+}
+
+// seccompArch 过滤器支持的一种架构，x32和x86_64的seccomp_data.arch相同，系统调用号带有syscallBit
+type seccompArch struct {
+	name       string // 配置文件 architectures 中的名字
+	goarch     string // 规则 includes、excludes 的 arches 中的名字
+	auditArch  uint32
+	syscallBit uint32
+	numbers    map[string]int
+}
+
+// otherArchitectures 本机内核不能运行的架构，配置中出现时忽略，docker的配置通常列出所有架构
+var otherArchitectures = []string{
+	"SCMP_ARCH_X86", "SCMP_ARCH_X86_64", "SCMP_ARCH_X32", "SCMP_ARCH_ARM", "SCMP_ARCH_AARCH64",
+	"SCMP_ARCH_MIPS", "SCMP_ARCH_MIPS64", "SCMP_ARCH_MIPS64N32", "SCMP_ARCH_MIPSEL", "SCMP_ARCH_MIPSEL64",
+	"SCMP_ARCH_MIPSEL64N32", "SCMP_ARCH_PPC", "SCMP_ARCH_PPC64", "SCMP_ARCH_PPC64LE", "SCMP_ARCH_S390",
+	"SCMP_ARCH_S390X", "SCMP_ARCH_PARISC", "SCMP_ARCH_PARISC64", "SCMP_ARCH_RISCV64", "SCMP_ARCH_LOONGARCH64",
+}
+
+// filterArches 返回需要生成规则的架构，本机架构总是在第一个。
+// 本机内核能运行的架构（amd64上的x86、x32，arm64上的arm）只有列在 architectures 中时才允许，
+// 本机内核不能运行的架构忽略，不认识的架构名返回错误
+func (s *Seccomp) filterArches() ([]*seccompArch, error) {
+	arches := []*seccompArch{seccompArches[0]}
+	for _, name := range s.Architectures {
+		found := false
+		for _, arch := range seccompArches {
+			if arch.name != name {
+				continue
+			}
+			found = true
+			if !containsArch(arches, arch) {
+				arches = append(arches, arch)
+			}
+		}
+		if !found && !containsString(otherArchitectures, name) {
+			return nil, fmt.Errorf("unsupported seccomp architecture %q", name)
+		}
+	}
+	return arches, nil
+}
+
+func containsArch(arches []*seccompArch, arch *seccompArch) bool {
+	for _, a := range arches {
+		if a == arch {
+			return true
+		}
+	}
+	return false
+}
+
+// BuildFilter 把配置编译成BPF过滤器，caps是容器拥有的capability，用来判断规则是否生效。
+// 过滤器先按seccomp_data.arch跳到各架构自己的规则，没有列在 architectures 中的架构直接杀死进程；
+// 名字不在某个架构系统调用表中的规则在这个架构上被忽略，docker的配置里有很多只存在于其他架构的系统调用
+func (s *Seccomp) BuildFilter(caps []string) ([]unix.SockFilter, error) {
+	if nativeAuditArch == 0 {
+		return nil, fmt.Errorf("seccomp is not supported on %s", runtime.GOARCH)
+	}
+
+	defaultAction, err := seccompAction(s.DefaultAction, s.DefaultErrnoRet)
+	if err != nil {
+		return nil, err
+	}
+	arches, err := s.filterArches()
+	if err != nil {
+		return nil, err
+	}
+
+	bodies := make([][]unix.SockFilter, len(arches))
+	for i, arch := range arches {
+		if bodies[i], err = s.archRules(arch, caps, defaultAction); err != nil {
+			return nil, err
+		}
+	}
+
+	// 和其他架构共用auditArch、靠syscallBit区分的架构（x32）由共用的架构在规则之前跳转过去
+	var primary []int
+	for i, arch := range arches {
+		if arch.syscallBit == 0 {
+			primary = append(primary, i)
+			if subArchBit(arch) != 0 {
+				bodies[i] = append(make([]unix.SockFilter, 3), bodies[i]...)
+			}
+		}
+	}
+
+	// 规则可能很长，超出条件跳转8位的偏移，用BPF_JA跳到各架构的规则
+	starts := make([]int, len(arches))
+	pc := 2*len(primary) + 2
+	for i := range arches {
+		starts[i] = pc
+		pc += len(bodies[i])
+	}
+
+	filter := []unix.SockFilter{bpfStmt(unix.BPF_LD|unix.BPF_W|unix.BPF_ABS, seccompDataArch)}
+	for _, i := range primary {
+		filter = append(filter,
+			bpfJump(unix.BPF_JMP|unix.BPF_JEQ|unix.BPF_K, arches[i].auditArch, 0, 1),
+			bpfStmt(unix.BPF_JMP|unix.BPF_JA, uint32(starts[i]-len(filter)-2)),
+		)
+	}
+	filter = append(filter, bpfStmt(unix.BPF_RET|unix.BPF_K, seccompRetKillProcess))
+
+	for i, arch := range arches {
+		if arch.syscallBit == 0 && subArchBit(arch) != 0 {
+			// 系统调用号带有syscallBit时跳到x32的规则，x32不允许时杀死进程
+			jump := bpfStmt(unix.BPF_RET|unix.BPF_K, seccompRetKillProcess)
+			for j, sub := range arches {
+				if sub.auditArch == arch.auditArch && sub.syscallBit != 0 {
+					jump = bpfStmt(unix.BPF_JMP|unix.BPF_JA, uint32(starts[j]-starts[i]-3))
+				}
+			}
+			bodies[i][0] = bpfStmt(unix.BPF_LD|unix.BPF_W|unix.BPF_ABS, seccompDataNr)
+			bodies[i][1] = bpfJump(unix.BPF_JMP|unix.BPF_JGE|unix.BPF_K, subArchBit(arch), 0, 1)
+			bodies[i][2] = jump
+		}
+		filter = append(filter, bodies[i]...)
+	}
+
+	if len(filter) > unix.BPF_MAXINSNS {
+		return nil, fmt.Errorf("seccomp filter too large: %d instructions", len(filter))
+	}
+	return filter, nil
+}
+
+// archRules 生成一种架构上的规则，最后是默认动作
+func (s *Seccomp) archRules(arch *seccompArch, caps []string, defaultAction uint32) ([]unix.SockFilter, error) {
+	var filter []unix.SockFilter
+	for _, rule := range s.Syscalls {
+		ok, err := rule.applies(caps, arch.goarch)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			continue
+		}
+
+		action, err := seccompAction(rule.Action, rule.ErrnoRet)
+		if err != nil {
+			return nil, err
+		}
+		names := rule.Names
+		if rule.Name != "" {
+			names = append([]string{rule.Name}, names...)
+		}
+		for _, name := range names {
+			nr, ok := arch.numbers[name]
+			if !ok {
+				continue
+			}
+			block, err := ruleBlock(uint32(nr)|arch.syscallBit, rule.Args, action)
+			if err != nil {
+				return nil, fmt.Errorf("syscall %s: %v", name, err)
+			}
+			filter = append(filter, block...)
+		}
+	}
+	return append(filter, bpfStmt(unix.BPF_RET|unix.BPF_K, defaultAction)), nil
+}
+
+// subArchBit 返回和arch共用auditArch的架构的syscallBit，没有这样的架构时返回0
+func subArchBit(arch *seccompArch) uint32 {
+	for _, a := range seccompArches {
+		if a.auditArch == arch.auditArch && a.syscallBit != 0 {
+			return a.syscallBit
+		}
+	}
+	return 0
+}
+
+// applies 判断规则对当前容器和架构是否生效
+func (r *Syscall) applies(caps []string, goarch string) (bool, error) {
+	for _, c := range r.Excludes.Caps {
+		if containsString(caps, c) {
+			return false, nil
+		}
+	}
+	if containsString(r.Excludes.Arches, goarch) {
+		return false, nil
+	}
+	for _, c := range r.Includes.Caps {
+		if !containsString(caps, c) {
+			return false, nil
+		}
+	}
+	if len(r.Includes.Arches) > 0 && !containsString(r.Includes.Arches, goarch) {
+		return false, nil
+	}
+	if r.Includes.MinKernel != "" {
+		return kernelAtLeast(r.Includes.MinKernel)
+	}
+	return true, nil
+}
+
+func seccompAction(action string, errnoRet *uint) (uint32, error) {
+	// 没有指定errnoRet时和docker一样返回EPERM
+	errno := uint32(unix.EPERM)
+	if errnoRet != nil {
+		errno = uint32(*errnoRet)
+	}
+
+	switch action {
+	case ActKill, ActKillThread:
+		return seccompRetKillThread, nil
+	case ActKillProcess:
+		return seccompRetKillProcess, nil
+	case ActTrap:
+		return seccompRetTrap, nil
+	case ActErrno:
+		return seccompRetErrno | errno&0xffff, nil
+	case ActTrace:
+		return seccompRetTrace | errno&0xffff, nil
+	case ActLog:
+		return seccompRetLog, nil
+	case ActAllow:
+		return seccompRetAllow, nil
+	}
+	return 0, fmt.Errorf("unsupported seccomp action %q", action)
+}
+
+// 跳转到规则末尾的占位偏移，生成完规则之后再替换成真实的偏移
+const jumpToNextRule = 0xff
+
+// ruleBlock 生成一条规则的指令：系统调用号和所有参数条件都满足时返回action，否则跳到下一条规则。
+// 参数比较会覆盖累加器，所以每条规则都重新加载系统调用号
+func ruleBlock(nr uint32, args []*Arg, action uint32) ([]unix.SockFilter, error) {
+	block := []unix.SockFilter{
+		bpfStmt(unix.BPF_LD|unix.BPF_W|unix.BPF_ABS, seccompDataNr),
+		bpfJump(unix.BPF_JMP|unix.BPF_JEQ|unix.BPF_K, nr, 0, jumpToNextRule),
+	}
+	for _, arg := range args {
+		checks, err := argChecks(arg)
+		if err != nil {
+			return nil, err
+		}
+		block = append(block, checks...)
+	}
+	block = append(block, bpfStmt(unix.BPF_RET|unix.BPF_K, action))
+
+	for i := range block {
+		if block[i].Code&0x07 != unix.BPF_JMP {
+			continue
+		}
+		offset := len(block) - i - 1
+		if offset >= jumpToNextRule {
+			return nil, fmt.Errorf("too many argument conditions")
+		}
+		if block[i].Jt == jumpToNextRule {
+			block[i].Jt = uint8(offset)
+		}
+		if block[i].Jf == jumpToNextRule {
+			block[i].Jf = uint8(offset)
+		}
+	}
+	return block, nil
+}
+
+// argChecks 生成64位参数的比较指令，BPF只能加载32位，先比较高32位再比较低32位。
+// 条件满足时执行到这组指令之后，不满足时跳到下一条规则
+func argChecks(arg *Arg) ([]unix.SockFilter, error) {
+	if arg.Index > 5 {
+		return nil, fmt.Errorf("invalid argument index %d", arg.Index)
+	}
+	// amd64和arm64都是小端，低32位在前
+	lo := seccompDataArgs + 8*uint32(arg.Index)
+	hi := lo + 4
+	loadHi := bpfStmt(unix.BPF_LD|unix.BPF_W|unix.BPF_ABS, hi)
+	loadLo := bpfStmt(unix.BPF_LD|unix.BPF_W|unix.BPF_ABS, lo)
+	vHi, vLo := uint32(arg.Value>>32), uint32(arg.Value)
+
+	const (
+		jeq = unix.BPF_JMP | unix.BPF_JEQ | unix.BPF_K
+		jgt = unix.BPF_JMP | unix.BPF_JGT | unix.BPF_K
+		jge = unix.BPF_JMP | unix.BPF_JGE | unix.BPF_K
+		and = unix.BPF_ALU | unix.BPF_AND | unix.BPF_K
+	)
+	next := uint8(jumpToNextRule)
+
+	switch arg.Op {
+	case OpEqualTo:
+		return []unix.SockFilter{
+			loadHi, bpfJump(jeq, vHi, 0, next),
+			loadLo, bpfJump(jeq, vLo, 0, next),
+		}, nil
+	case OpNotEqual:
+		// 高32位不相等时直接满足条件
+		return []unix.SockFilter{
+			loadHi, bpfJump(jeq, vHi, 0, 2),
+			loadLo, bpfJump(jeq, vLo, next, 0),
+		}, nil
+	case OpMaskedEqual:
+		mask := arg.Value
+		datum := arg.ValueTwo & mask
+		return []unix.SockFilter{
+			loadHi, bpfStmt(and, uint32(mask>>32)), bpfJump(jeq, uint32(datum>>32), 0, next),
+			loadLo, bpfStmt(and, uint32(mask)), bpfJump(jeq, uint32(datum), 0, next),
+		}, nil
+	case OpGreaterThan, OpGreaterEqual:
+		// 高32位大于时满足，小于时不满足，相等时再比较低32位
+		last := bpfJump(jgt, vLo, 0, next)
+		if arg.Op == OpGreaterEqual {
+			last = bpfJump(jge, vLo, 0, next)
+		}
+		return []unix.SockFilter{
+			loadHi, bpfJump(jgt, vHi, 3, 0), bpfJump(jeq, vHi, 0, next),
+			loadLo, last,
+		}, nil
+	case OpLessThan, OpLessEqual:
+		// 高32位大于时不满足，小于时满足，相等时再比较低32位
+		last := bpfJump(jge, vLo, next, 0)
+		if arg.Op == OpLessEqual {
+			last = bpfJump(jgt, vLo, next, 0)
+		}
+		return []unix.SockFilter{
+			loadHi, bpfJump(jgt, vHi, next, 0), bpfJump(jeq, vHi, 0, 2),
+			loadLo, last,
+		}, nil
+	}
+	return nil, fmt.Errorf("unsupported seccomp operator %q", arg.Op)
+}
+
+// EncodeSeccompFilter 把过滤器编码成十六进制字符串，通过环境变量传给exec的 enterns，
+// 每条指令16个字符，依次是 code(4) jt(2) jf(2) k(8)
+func EncodeSeccompFilter(filter []unix.SockFilter) string {
+	var b strings.Builder
+	for _, f := range filter {
+		fmt.Fprintf(&b, "%04x%02x%02x%08x", f.Code, f.Jt, f.Jf, f.K)
+	}
+	return b.String()
+}
+
+func bpfStmt(code uint16, k uint32) unix.SockFilter {
+	return unix.SockFilter{Code: code, K: k}
+}
+
+func bpfJump(code uint16, k uint32, jt, jf uint8) unix.SockFilter {
+	return unix.SockFilter{Code: code, Jt: jt, Jf: jf, K: k}
+}
+
+// kernelAtLeast 判断当前内核版本是否不低于 major.minor
+func kernelAtLeast(version string) (bool, error) {
+	want, err := parseKernelVersion(version)
+	if err != nil {
+		return false, fmt.Errorf("invalid minKernel %q", version)
+	}
+
+	var uts unix.Utsname
+	if err := unix.Uname(&uts); err != nil {
+		return false, err
+	}
+	release := string(uts.Release[:])
+	if i := strings.IndexByte(release, 0); i >= 0 {
+		release = release[:i]
+	}
+	got, err := parseKernelVersion(release)
+	if err != nil {
+		return false, fmt.Errorf("invalid kernel release %q", release)
+	}
+	return got[0] > want[0] || got[0] == want[0] && got[1] >= want[1], nil
+}
+
+// parseKernelVersion 解析 5.10.0-xxx 这样的版本号中的主次版本号
+func parseKernelVersion(version string) ([2]int, error) {
+	var v [2]int
+	fields := strings.SplitN(version, ".", 3)
+	if len(fields) < 2 {
+		return v, fmt.Errorf("invalid version %q", version)
+	}
+	for i := range v {
+		n, err := strconv.Atoi(strings.TrimRightFunc(fields[i], func(r rune) bool { return r < '0' || r > '9' }))
+		if err != nil {
+			return v, err
+		}
+		v[i] = n
+	}
+	return v, nil
+}
+
+// installSeccomp 在当前线程上安装seccomp过滤器，之后在同一个线程上exec的进程都受它限制。
+// 没有设置no_new_privs时需要CAP_SYS_ADMIN，所以要在去掉capability之前调用
+func installSeccomp(profile *Seccomp, caps []string) error {
+	filter, err := profile.BuildFilter(caps)
+	if err != nil {
+		return err
+	}
+
+	prog := unix.SockFprog{
+		Len:    uint16(len(filter)),
+		Filter: &filter[0],
+	}
+	if err := unix.Prctl(unix.PR_SET_SECCOMP, unix.SECCOMP_MODE_FILTER, uintptr(unsafe.Pointer(&prog)), 0, 0); err != nil {
+		return fmt.Errorf("prctl(PR_SET_SECCOMP) error: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,199 @@
+package container
+
+import (
+	"encoding/binary"
+	"testing"
+
+	"golang.org/x/sys/unix"
+)
+
+// runFilter 用一个简单的BPF解释器执行过滤器，返回过滤器对这次系统调用的动作
+func runFilter(t *testing.T, filter []unix.SockFilter, arch uint32, nr int, args ...uint64) uint32 {
+	data := make([]byte, 64)
+	binary.LittleEndian.PutUint32(data[seccompDataNr:], uint32(nr))
+	binary.LittleEndian.PutUint32(data[seccompDataArch:], arch)
+	for i, a := range args {
+		binary.LittleEndian.PutUint64(data[seccompDataArgs+8*i:], a)
+	}
+
+	var acc uint32
+	for pc := 0; pc < len(filter); pc++ {
+		ins := filter[pc]
+		switch ins.Code {
+		case unix.BPF_LD | unix.BPF_W | unix.BPF_ABS:
+			acc = binary.LittleEndian.Uint32(data[ins.K:])
+		case unix.BPF_ALU | unix.BPF_AND | unix.BPF_K:
+			acc &= ins.K
+		case unix.BPF_RET | unix.BPF_K:
+			return ins.K
+		case unix.BPF_JMP | unix.BPF_JA:
+			pc += int(ins.K)
+		default:
+			var ok bool
+			switch ins.Code {
+			case unix.BPF_JMP | unix.BPF_JEQ | unix.BPF_K:
+				ok = acc == ins.K
+			case unix.BPF_JMP | unix.BPF_JGT | unix.BPF_K:
+				ok = acc > ins.K
+			case unix.BPF_JMP | unix.BPF_JGE | unix.BPF_K:
+				ok = acc >= ins.K
+			default:
+				t.Fatalf("unexpected instruction %#x at %d", ins.Code, pc)
+			}
+			if ok {
+				pc += int(ins.Jt)
+			} else {
+				pc += int(ins.Jf)
+			}
+		}
+	}
+	t.Fatalf("filter ended without return")
+	return 0
+}
+
+func TestSeccompBuildFilter(t *testing.T) {
+	if nativeAuditArch == 0 {
+		t.Skip("seccomp is not supported on this architecture")
+	}
+
+	enosys := uint(38)
+	profile := &Seccomp{
+		DefaultAction: ActErrno,
+		Syscalls: []*Syscall{
+			{Names: []string{"read", "not_a_syscall"}, Action: ActAllow},
+			{Names: []string{"write"}, Action: ActAllow, Args: []*Arg{{Index: 0, Value: 1<<32 | 2, Op: OpEqualTo}}},
+			{Names: []string{"close"}, Action: ActAllow, Args: []*Arg{{Index: 0, Value: 1<<32 | 10, Op: OpGreaterThan}}},
+			{Names: []string{"dup"}, Action: ActAllow, Args: []*Arg{{Index: 0, Value: 10, Op: OpLessEqual}}},
+			{Names: []string{"clone"}, Action: ActAllow, Args: []*Arg{{Index: 0, Value: 0x7E020000, Op: OpMaskedEqual}}},
+			{Names: []string{"kill"}, Action: ActAllow, Args: []*Arg{{Index: 1, Value: 9, Op: OpNotEqual}}},
+			{Names: []string{"mount"}, Action: ActAllow, Includes: Filter{Caps: []string{"CAP_SYS_ADMIN"}}},
+			{Names: []string{"mkdir"}, Action: ActErrno, ErrnoRet: &enosys},
+		},
+	}
+	filter, err := profile.BuildFilter([]string{"CAP_CHOWN"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	allow := uint32(seccompRetAllow)
+	eperm := uint32(seccompRetErrno | 1)
+	tests := []struct {
+		name string
+		args []uint64
+		want uint32
+	}{
+		{"read", nil, allow},
+		{"write", []uint64{1<<32 | 2}, allow},
+		{"write", []uint64{2}, eperm},
+		{"close", []uint64{1<<32 | 11}, allow},
+		{"close", []uint64{2 << 32}, allow},
+		{"close", []uint64{1<<32 | 10}, eperm},
+		{"close", []uint64{100}, eperm},
+		{"dup", []uint64{10}, allow},
+		{"dup", []uint64{11}, eperm},
+		{"dup", []uint64{1<<32 | 1}, eperm},
+		{"clone", []uint64{0x11}, allow},
+		{"clone", []uint64{0x20000}, eperm},
+		{"kill", []uint64{1, 15}, allow},
+		{"kill", []uint64{1, 9}, eperm},
+		{"kill", []uint64{1, 1<<32 | 9}, allow},
+		{"mount", nil, eperm},
+		{"mkdir", nil, uint32(seccompRetErrno | 38)},
+		{"openat", nil, eperm},
+	}
+	for _, tt := range tests {
+		nr, ok := syscallNumbers[tt.name]
+		if !ok {
+			continue
+		}
+		if got := runFilter(t, filter, nativeAuditArch, nr, tt.args...); got != tt.want {
+			t.Errorf("%s%v = %#x, want %#x", tt.name, tt.args, got, tt.want)
+		}
+	}
+
+	if got := runFilter(t, filter, 0x40000003, syscallNumbers["read"]); got != seccompRetKillProcess {
+		t.Errorf("foreign arch = %#x, want kill process", got)
+	}
+}
+
+func TestSeccompArchitectures(t *testing.T) {
+	if nativeAuditArch == 0 {
+		t.Skip("seccomp is not supported on this architecture")
+	}
+
+	profile := &Seccomp{
+		DefaultAction: ActErrno,
+		Syscalls: []*Syscall{
+			{Names: []string{"read", "mmap2"}, Action: ActAllow},
+			{Names: []string{"getpid"}, Action: ActAllow, Includes: Filter{Arches: []string{seccompArches[0].goarch}}},
+		},
+	}
+	filter, err := profile.BuildFilter(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	eperm := uint32(seccompRetErrno | 1)
+	for _, arch := range seccompArches[1:] {
+		if got := runFilter(t, filter, arch.auditArch, arch.numbers["read"]|int(arch.syscallBit)); got != seccompRetKillProcess {
+			t.Errorf("%s not listed = %#x, want kill process", arch.name, got)
+		}
+	}
+
+	// docker的配置会列出本机不能运行的架构，忽略它们
+	for _, arch := range seccompArches {
+		profile.Architectures = append(profile.Architectures, arch.name)
+	}
+	profile.Architectures = append(profile.Architectures, "SCMP_ARCH_S390X")
+	filter, err = profile.BuildFilter(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, arch := range seccompArches {
+		run := func(name string) uint32 {
+			return runFilter(t, filter, arch.auditArch, arch.numbers[name]|int(arch.syscallBit))
+		}
+		if got := run("read"); got != seccompRetAllow {
+			t.Errorf("%s read = %#x, want allow", arch.name, got)
+		}
+		if _, ok := arch.numbers["mmap2"]; ok {
+			if got := run("mmap2"); got != seccompRetAllow {
+				t.Errorf("%s mmap2 = %#x, want allow", arch.name, got)
+			}
+		}
+		want := eperm
+		if arch == seccompArches[0] {
+			want = seccompRetAllow
+		}
+		if got := run("getpid"); got != want {
+			t.Errorf("%s getpid = %#x, want %#x", arch.name, got, want)
+		}
+		if got := run("write"); got != eperm {
+			t.Errorf("%s write = %#x, want EPERM", arch.name, got)
+		}
+	}
+
+	profile.Architectures = []string{"SCMP_ARCH_UNKNOWN"}
+	if _, err := profile.BuildFilter(nil); err == nil {
+		t.Error("unknown architecture should fail")
+	}
+}
+
+func TestDefaultSeccompProfile(t *testing.T) {
+	if nativeAuditArch == 0 {
+		t.Skip("seccomp is not supported on this architecture")
+	}
+
+	filter, err := DefaultSeccompProfile().BuildFilter(DefaultCapabilities)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := runFilter(t, filter, nativeAuditArch, syscallNumbers["execve"]); got != seccompRetAllow {
+		t.Errorf("execve = %#x, want allow", got)
+	}
+	if got := runFilter(t, filter, nativeAuditArch, syscallNumbers["mount"]); got != seccompRetErrno|1 {
+		t.Errorf("mount without CAP_SYS_ADMIN = %#x, want EPERM", got)
+	}
+	if got := runFilter(t, filter, nativeAuditArch, syscallNumbers["unshare"]); got != seccompRetErrno|1 {
+		t.Errorf("unshare without CAP_SYS_ADMIN = %#x, want EPERM", got)
+	}
+}
@@ -0,0 +1,14 @@
+//go:build !amd64 && !arm64
+// +build !amd64,!arm64
+
+package container
+
+const (
+	// 其他架构还没有系统调用表，不支持seccomp
+	nativeAuditArch = 0
+	x32SyscallBit   = 0
+)
+
+var syscallNumbers = map[string]int{}
+
+var seccompArches []*seccompArch
@@ -0,0 +1,128 @@
+package container
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// Ulimit 容器进程的一项资源限制，对应一次setrlimit系统调用
+type Ulimit struct {
+	Name string `json:"name"`
+	Soft uint64 `json:"soft"`
+	Hard uint64 `json:"hard"`
+}
+
+// rlimits --ulimit 可以使用的名字和对应的 RLIMIT_*
+var rlimits = map[string]int{
+	"as":         unix.RLIMIT_AS,
+	"core":       unix.RLIMIT_CORE,
+	"cpu":        unix.RLIMIT_CPU,
+	"data":       unix.RLIMIT_DATA,
+	"fsize":      unix.RLIMIT_FSIZE,
+	"locks":      unix.RLIMIT_LOCKS,
+	"memlock":    unix.RLIMIT_MEMLOCK,
+	"msgqueue":   unix.RLIMIT_MSGQUEUE,
+	"nice":       unix.RLIMIT_NICE,
+	"nofile":     unix.RLIMIT_NOFILE,
+	"nproc":      unix.RLIMIT_NPROC,
+	"rss":        unix.RLIMIT_RSS,
+	"rtprio":     unix.RLIMIT_RTPRIO,
+	"rttime":     unix.RLIMIT_RTTIME,
+	"sigpending": unix.RLIMIT_SIGPENDING,
+	"stack":      unix.RLIMIT_STACK,
+}
+
+// ParseUlimit 解析 --ulimit 的参数 name=soft[:hard]，例如 nofile=65536:65536。
+// 没有指定hard时和soft相同，-1 或 unlimited 表示不限制
+func ParseUlimit(spec string) (*Ulimit, error) {
+	parts := strings.SplitN(spec, "=", 2)
+	if len(parts) != 2 {
+		return nil, fmt.Errorf("invalid ulimit %q, expect name=soft[:hard]", spec)
+	}
+
+	u := &Ulimit{Name: parts[0]}
+	limits := strings.SplitN(parts[1], ":", 2)
+	var err error
+	if u.Soft, err = parseRlimitValue(limits[0]); err != nil {
+		return nil, fmt.Errorf("invalid ulimit %q: %v", spec, err)
+	}
+	u.Hard = u.Soft
+	if len(limits) == 2 {
+		if u.Hard, err = parseRlimitValue(limits[1]); err != nil {
+			return nil, fmt.Errorf("invalid ulimit %q: %v", spec, err)
+		}
+	}
+	if err := u.validate(); err != nil {
+		return nil, err
+	}
+	return u, nil
+}
+
+func parseRlimitValue(s string) (uint64, error) {
+	if s == "-1" || s == "unlimited" {
+		return unix.RLIM_INFINITY, nil
+	}
+	return strconv.ParseUint(s, 10, 64)
+}
+
+// validate 检查名字是否支持，以及soft不能超过hard
+func (u *Ulimit) validate() error {
+	if _, ok := rlimits[u.Name]; !ok {
+		return fmt.Errorf("invalid ulimit type %q", u.Name)
+	}
+	if u.Soft > u.Hard {
+		return fmt.Errorf("ulimit %s soft limit %d is larger than hard limit %d", u.Name, u.Soft, u.Hard)
+	}
+	return nil
+}
+
+// MergeUlimits 用 --ulimit 指定的限制覆盖默认配置中的同名限制，按名字排序返回
+func MergeUlimits(defaults map[string]*Ulimit, ulimits []*Ulimit) ([]*Ulimit, error) {
+	merged := make(map[string]*Ulimit)
+	for name, u := range defaults {
+		// 配置文件中的名字以key为准，和docker的daemon.json一致
+		d := *u
+		d.Name = name
+		if err := d.validate(); err != nil {
+			return nil, err
+		}
+		merged[name] = &d
+	}
+	for _, u := range ulimits {
+		merged[u.Name] = u
+	}
+
+	result := make([]*Ulimit, 0, len(merged))
+	for _, u := range merged {
+		result = append(result, u)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Name < result[j].Name })
+	return result, nil
+}
+
+// EncodeUlimits 把资源限制编码成 resource:soft:hard,... 传给exec，enterns 中不需要再解析名字
+func EncodeUlimits(ulimits []*Ulimit) string {
+	var items []string
+	for _, u := range ulimits {
+		items = append(items, fmt.Sprintf("%d:%d:%d", rlimits[u.Name], u.Soft, u.Hard))
+	}
+	return strings.Join(items, ",")
+}
+
+// setRlimits 设置init进程的资源限制，exec之后用户命令继承这些限制。
+// 提高hard limit需要CAP_SYS_RESOURCE，所以要在去掉capability之前调用。
+// 使用syscall包设置，syscall.Exec就不会把RLIMIT_NOFILE恢复成Go运行时启动前的值
+func setRlimits(ulimits []*Ulimit) error {
+	for _, u := range ulimits {
+		rlimit := &syscall.Rlimit{Cur: u.Soft, Max: u.Hard}
+		if err := syscall.Setrlimit(rlimits[u.Name], rlimit); err != nil {
+			return fmt.Errorf("set ulimit %s=%d:%d error: %v", u.Name, u.Soft, u.Hard, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,57 @@
+package container
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+
+	"golang.org/x/sys/unix"
+)
+
+func TestParseUlimit(t *testing.T) {
+	tests := map[string]Ulimit{
+		"nofile=1024":         {Name: "nofile", Soft: 1024, Hard: 1024},
+		"nofile=1024:65536":   {Name: "nofile", Soft: 1024, Hard: 65536},
+		"core=-1":             {Name: "core", Soft: unix.RLIM_INFINITY, Hard: unix.RLIM_INFINITY},
+		"memlock=0:unlimited": {Name: "memlock", Soft: 0, Hard: unix.RLIM_INFINITY},
+	}
+	for spec, want := range tests {
+		got, err := ParseUlimit(spec)
+		if err != nil {
+			t.Errorf("ParseUlimit(%q) error: %v", spec, err)
+			continue
+		}
+		if *got != want {
+			t.Errorf("ParseUlimit(%q) = %+v, want %+v", spec, *got, want)
+		}
+	}
+
+	for _, spec := range []string{"nofile", "foo=1", "nofile=2:1", "nofile=a", "nofile=1:b"} {
+		if _, err := ParseUlimit(spec); err == nil {
+			t.Errorf("ParseUlimit(%q) expected error", spec)
+		}
+	}
+}
+
+func TestMergeUlimits(t *testing.T) {
+	defaults := map[string]*Ulimit{
+		"nofile": {Soft: 1024, Hard: 4096},
+		"core":   {Name: "core", Soft: 0, Hard: 0},
+	}
+	nofile, _ := ParseUlimit("nofile=65536")
+	got, err := MergeUlimits(defaults, []*Ulimit{nofile})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []*Ulimit{{Name: "core"}, {Name: "nofile", Soft: 65536, Hard: 65536}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+	if s, want := EncodeUlimits(got), fmt.Sprintf("%d:0:0,%d:65536:65536", unix.RLIMIT_CORE, unix.RLIMIT_NOFILE); s != want {
+		t.Errorf("EncodeUlimits = %q, want %q", s, want)
+	}
+
+	if _, err := MergeUlimits(map[string]*Ulimit{"foo": {}}, nil); err == nil {
+		t.Errorf("expected error for unknown default ulimit")
+	}
+}
@@ -0,0 +1,231 @@
+package container
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+
+	"golang.org/x/sys/unix"
+)
+
+// 解析 --user 时使用的容器内的用户和组文件，pivot_root之后就是容器自己的文件
+var (
+	passwdFile = "/etc/passwd"
+	groupFile  = "/etc/group"
+)
+
+// execUser --user 解析出来的用户
+type execUser struct {
+	uid    int
+	gid    int
+	groups []int
+}
+
+// passwdEntry /etc/passwd 中的一行
+type passwdEntry struct {
+	name string
+	uid  int
+	gid  int
+}
+
+// groupEntry /etc/group 中的一行
+type groupEntry struct {
+	name    string
+	gid     int
+	members []string
+}
+
+// lookupUser 解析 --user 的参数 name|uid[:group|gid]。用户名和组名在容器的 /etc/passwd 和 /etc/group 中查找，
+// 数字形式的uid不要求在 /etc/passwd 中存在，这时gid默认是0，和docker的行为一致。
+// 附加组是 /etc/group 中包含这个用户名的所有组
+func lookupUser(spec string) (*execUser, error) {
+	return lookupUserIn(spec, passwdFile, groupFile)
+}
+
+// lookupUserIn 和 lookupUser 相同，使用指定的passwd和group文件
+func lookupUserIn(spec, passwd, group string) (*execUser, error) {
+	userPart, groupPart := spec, ""
+	if i := strings.Index(spec, ":"); i >= 0 {
+		userPart, groupPart = spec[:i], spec[i+1:]
+	}
+	if userPart == "" {
+		return nil, fmt.Errorf("invalid user %q", spec)
+	}
+
+	users, err := parsePasswd(passwd)
+	if err != nil {
+		return nil, err
+	}
+	groups, err := parseGroup(group)
+	if err != nil {
+		return nil, err
+	}
+
+	u := &execUser{}
+	uid, err := strconv.Atoi(userPart)
+	isUID := err == nil
+	name := ""
+	for _, p := range users {
+		if (isUID && p.uid == uid) || (!isUID && p.name == userPart) {
+			u.uid, u.gid, name = p.uid, p.gid, p.name
+			break
+		}
+	}
+	if name == "" {
+		if !isUID {
+			return nil, fmt.Errorf("unable to find user %s: no matching entries in passwd file", userPart)
+		}
+		u.uid = uid
+	}
+
+	if groupPart != "" {
+		gid, err := strconv.Atoi(groupPart)
+		if err != nil {
+			gid = -1
+			for _, g := range groups {
+				if g.name == groupPart {
+					gid = g.gid
+					break
+				}
+			}
+			if gid == -1 {
+				return nil, fmt.Errorf("unable to find group %s: no matching entries in group file", groupPart)
+			}
+		}
+		u.gid = gid
+	}
+
+	if name != "" {
+		for _, g := range groups {
+			for _, m := range g.members {
+				if m == name {
+					u.groups = append(u.groups, g.gid)
+					break
+				}
+			}
+		}
+	}
+	return u, nil
+}
+
+// LookupExecUser 解析exec -u 的参数 name|uid[:group|gid]，用户名和组名在容器进程pid的
+// /etc/passwd 和 /etc/group 中查找。返回 uid:gid[:附加组,...]，enterns 直接按数字切换用户
+func LookupExecUser(pid, spec string) (string, error) {
+	return lookupUserInRoot(fmt.Sprintf("/proc/%s/root", pid), spec)
+}
+
+func lookupUserInRoot(root, spec string) (string, error) {
+	passwd, err := resolveInRoot(root, passwdFile)
+	if err != nil {
+		return "", err
+	}
+	group, err := resolveInRoot(root, groupFile)
+	if err != nil {
+		return "", err
+	}
+	u, err := lookupUserIn(spec, passwd, group)
+	if err != nil {
+		return "", err
+	}
+
+	ids := fmt.Sprintf("%d:%d", u.uid, u.gid)
+	if len(u.groups) > 0 {
+		groups := make([]string, len(u.groups))
+		for i, g := range u.groups {
+			groups[i] = strconv.Itoa(g)
+		}
+		ids += ":" + strings.Join(groups, ",")
+	}
+	return ids, nil
+}
+
+// parsePasswd 解析passwd文件，文件不存在时返回空列表
+func parsePasswd(file string) ([]passwdEntry, error) {
+	var entries []passwdEntry
+	err := scanColonFile(file, func(fields []string) {
+		if len(fields) < 4 {
+			return
+		}
+		uid, err1 := strconv.Atoi(fields[2])
+		gid, err2 := strconv.Atoi(fields[3])
+		if err1 != nil || err2 != nil {
+			return
+		}
+		entries = append(entries, passwdEntry{name: fields[0], uid: uid, gid: gid})
+	})
+	return entries, err
+}
+
+// parseGroup 解析group文件，文件不存在时返回空列表
+func parseGroup(file string) ([]groupEntry, error) {
+	var entries []groupEntry
+	err := scanColonFile(file, func(fields []string) {
+		if len(fields) < 3 {
+			return
+		}
+		gid, err := strconv.Atoi(fields[2])
+		if err != nil {
+			return
+		}
+		g := groupEntry{name: fields[0], gid: gid}
+		if len(fields) > 3 && fields[3] != "" {
+			g.members = strings.Split(fields[3], ",")
+		}
+		entries = append(entries, g)
+	})
+	return entries, err
+}
+
+// scanColonFile 按行读取以冒号分隔的文件，跳过空行和注释
+func scanColonFile(file string, fn func(fields []string)) error {
+	f, err := os.Open(file)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fn(strings.Split(line, ":"))
+	}
+	return scanner.Err()
+}
+
+// switchUser 切换当前线程的用户，调用之前要锁定线程，之后在同一个线程上exec。
+// 先设置附加组，再依次设置gid和uid，设置uid之后就没有权限再修改gid了
+func switchUser(u *execUser) error {
+	// rootless容器的user namespace禁止了setgroups，跳过附加组
+	if err := unix.Setgroups(u.groups); err != nil && !setgroupsDenied() {
+		return fmt.Errorf("setgroups error: %v", err)
+	}
+	// 没有映射到user namespace中的id返回EINVAL，rootless容器中只映射了root
+	if err := unix.Setresgid(u.gid, u.gid, u.gid); err != nil {
+		if err == unix.EINVAL {
+			return fmt.Errorf("setgid %d error: gid is not mapped in the user namespace", u.gid)
+		}
+		return fmt.Errorf("setgid %d error: %v", u.gid, err)
+	}
+	if err := unix.Setresuid(u.uid, u.uid, u.uid); err != nil {
+		if err == unix.EINVAL {
+			return fmt.Errorf("setuid %d error: uid is not mapped in the user namespace", u.uid)
+		}
+		return fmt.Errorf("setuid %d error: %v", u.uid, err)
+	}
+	return nil
+}
+
+// setgroupsDenied 当前user namespace是否禁止了setgroups
+func setgroupsDenied() bool {
+	b, err := ioutil.ReadFile("/proc/self/setgroups")
+	return err == nil && strings.TrimSpace(string(b)) == "deny"
+}
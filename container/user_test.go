@@ -0,0 +1,100 @@
+package container
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"reflect"
+	"testing"
+)
+
+func TestLookupUser(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ddocker-user")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	oldPasswd, oldGroup := passwdFile, groupFile
+	defer func() { passwdFile, groupFile = oldPasswd, oldGroup }()
+	passwdFile = path.Join(dir, "passwd")
+	groupFile = path.Join(dir, "group")
+	_ = ioutil.WriteFile(passwdFile, []byte("root:x:0:0:root:/root:/bin/sh\n# comment\nalice:x:1000:1000::/home/alice:/bin/sh\n"), 0644)
+	_ = ioutil.WriteFile(groupFile, []byte("root:x:0:\nalice:x:1000:\nwheel:x:10:bob,alice\nstaff:x:50:\n"), 0644)
+
+	tests := []struct {
+		spec string
+		want *execUser
+	}{
+		{"alice", &execUser{uid: 1000, gid: 1000, groups: []int{10}}},
+		{"1000", &execUser{uid: 1000, gid: 1000, groups: []int{10}}},
+		{"alice:staff", &execUser{uid: 1000, gid: 50, groups: []int{10}}},
+		{"1234", &execUser{uid: 1234, gid: 0}},
+		{"1234:77", &execUser{uid: 1234, gid: 77}},
+		{"root", &execUser{uid: 0, gid: 0}},
+	}
+	for _, tt := range tests {
+		got, err := lookupUser(tt.spec)
+		if err != nil {
+			t.Errorf("lookupUser(%q) error: %v", tt.spec, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("lookupUser(%q) = %+v, want %+v", tt.spec, got, tt.want)
+		}
+	}
+
+	for _, spec := range []string{"bob", "alice:nogroup", ":10"} {
+		if _, err := lookupUser(spec); err == nil {
+			t.Errorf("lookupUser(%q) expected error", spec)
+		}
+	}
+}
+
+func TestLookupUserInRoot(t *testing.T) {
+	root, err := ioutil.TempDir("", "ddocker-root")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	// 容器内的 /etc/passwd 是指向绝对路径的符号链接，要在容器根目录下解析
+	os.MkdirAll(path.Join(root, "etc"), 0755)
+	os.MkdirAll(path.Join(root, "data"), 0755)
+	_ = ioutil.WriteFile(path.Join(root, "data/passwd"), []byte("alice:x:1000:1000::/home/alice:/bin/sh\n"), 0644)
+	_ = ioutil.WriteFile(path.Join(root, "etc/group"), []byte("alice:x:1000:\nwheel:x:10:alice\naudio:x:29:alice\n"), 0644)
+	if err := os.Symlink("/data/../data/passwd", path.Join(root, "etc/passwd")); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := map[string]string{
+		"alice":       "1000:1000:10,29",
+		"alice:audio": "1000:29:10,29",
+		"1234":        "1234:0",
+		"1234:wheel":  "1234:10",
+	}
+	for spec, want := range tests {
+		got, err := lookupUserInRoot(root, spec)
+		if err != nil {
+			t.Errorf("lookupUserInRoot(%q) error: %v", spec, err)
+			continue
+		}
+		if got != want {
+			t.Errorf("lookupUserInRoot(%q) = %q, want %q", spec, got, want)
+		}
+	}
+	if _, err := lookupUserInRoot(root, "bob"); err == nil {
+		t.Error("lookupUserInRoot(bob) expected error")
+	}
+
+	// 指向根目录之外的链接不能逃出容器根目录
+	os.Remove(path.Join(root, "etc/passwd"))
+	os.Symlink("../../../../../../etc/shadow", path.Join(root, "etc/passwd"))
+	got, err := resolveInRoot(root, "/etc/passwd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := path.Join(root, "etc/shadow"); got != want {
+		t.Errorf("resolveInRoot escaped the root: %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,255 @@
+package container
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	SubUIDFile = "/etc/subuid"
+	SubGIDFile = "/etc/subgid"
+
+	// --userns-remap default 使用的用户，和docker的dockremap一样需要在 /etc/subuid 和 /etc/subgid 中配置
+	DefaultRemapUser = "ddocker"
+)
+
+// IDMap 一段uid/gid映射，容器内的 [ContainerID, ContainerID+Size) 对应宿主机上的 [HostID, HostID+Size)
+type IDMap struct {
+	ContainerID int `json:"container_id"`
+	HostID      int `json:"host_id"`
+	Size        int `json:"size"`
+}
+
+// IDMapping 容器user namespace的uid和gid映射
+type IDMapping struct {
+	UIDMaps []IDMap `json:"uid_maps"`
+	GIDMaps []IDMap `json:"gid_maps"`
+}
+
+// NewIDMapping 根据 /etc/subuid 和 /etc/subgid 中分配给remapUser的id范围生成映射，
+// 容器内的root对应第一段范围的起始id
+func NewIDMapping(remapUser string) (*IDMapping, error) {
+	if remapUser == "default" {
+		remapUser = DefaultRemapUser
+	}
+
+	// subuid中可以写用户名也可以写uid
+	names := []string{remapUser}
+	if u, err := user.Lookup(remapUser); err == nil {
+		names = append(names, u.Uid)
+	} else if u, err := user.LookupId(remapUser); err == nil {
+		names = append(names, u.Username)
+	}
+
+	uidMaps, err := readSubIDFile(SubUIDFile, names)
+	if err != nil {
+		return nil, err
+	}
+	gidMaps, err := readSubIDFile(SubGIDFile, names)
+	if err != nil {
+		return nil, err
+	}
+	return &IDMapping{UIDMaps: uidMaps, GIDMaps: gidMaps}, nil
+}
+
+func readSubIDFile(file string, names []string) ([]IDMap, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	maps, err := parseSubIDs(f, names)
+	if err != nil {
+		return nil, fmt.Errorf("parse %s error: %v", file, err)
+	}
+	if len(maps) == 0 {
+		return nil, fmt.Errorf("no subordinate ids for user %s in %s", names[0], file)
+	}
+	return maps, nil
+}
+
+// parseSubIDs 解析 name:start:count 格式的subuid/subgid文件，
+// 同一个用户的多段范围按顺序依次映射到容器内连续的id上
+func parseSubIDs(r io.Reader, names []string) ([]IDMap, error) {
+	var maps []IDMap
+	next := 0
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fields := strings.Split(line, ":")
+		if len(fields) != 3 {
+			return nil, fmt.Errorf("invalid line %q", line)
+		}
+		if !containsString(names, fields[0]) {
+			continue
+		}
+
+		start, err := strconv.Atoi(fields[1])
+		if err != nil {
+			return nil, fmt.Errorf("invalid start id in line %q", line)
+		}
+		count, err := strconv.Atoi(fields[2])
+		if err != nil || count <= 0 {
+			return nil, fmt.Errorf("invalid id count in line %q", line)
+		}
+
+		maps = append(maps, IDMap{ContainerID: next, HostID: start, Size: count})
+		next += count
+	}
+	return maps, scanner.Err()
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+// RootPair 返回容器内root在宿主机上对应的uid和gid
+func (m *IDMapping) RootPair() (int, int) {
+	uid, _ := toHost(m.UIDMaps, 0)
+	gid, _ := toHost(m.GIDMaps, 0)
+	return uid, gid
+}
+
+// toHost 把容器内的id转换成宿主机上的id
+func toHost(maps []IDMap, id int) (int, bool) {
+	for _, m := range maps {
+		if id >= m.ContainerID && id < m.ContainerID+m.Size {
+			return m.HostID + id - m.ContainerID, true
+		}
+	}
+	return -1, false
+}
+
+// runningInUserNS 判断当前进程是否在新的user namespace中，初始user namespace的uid_map是 "0 0 4294967295"
+func runningInUserNS() bool {
+	b, err := ioutil.ReadFile("/proc/self/uid_map")
+	if err != nil {
+		return false
+	}
+	fields := strings.Fields(string(b))
+	return len(fields) != 3 || fields[0] != "0" || fields[1] != "0" || fields[2] != "4294967295"
+}
+
+func sysProcIDMaps(maps []IDMap) []syscall.SysProcIDMap {
+	var result []syscall.SysProcIDMap
+	for _, m := range maps {
+		result = append(result, syscall.SysProcIDMap{ContainerID: m.ContainerID, HostID: m.HostID, Size: m.Size})
+	}
+	return result
+}
+
+// shiftOwnership 把目录下所有文件的属主从宿主机上的id平移到映射后的id，
+// 镜像里属于root的文件在容器内依然属于root。映射范围之外的id保持不变
+func (m *IDMapping) shiftOwnership(dir string) error {
+	return filepath.Walk(dir, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		stat, ok := info.Sys().(*syscall.Stat_t)
+		if !ok {
+			return nil
+		}
+
+		uid, uidOK := toHost(m.UIDMaps, int(stat.Uid))
+		gid, gidOK := toHost(m.GIDMaps, int(stat.Gid))
+		if !uidOK || !gidOK {
+			logrus.Warnf("%s owner %d:%d is out of the id mapping, keep it", p, stat.Uid, stat.Gid)
+			return nil
+		}
+
+		if err := os.Lchown(p, uid, gid); err != nil {
+			return err
+		}
+		// chown会清掉setuid/setgid位，需要重新设置回去
+		if info.Mode()&(os.ModeSetuid|os.ModeSetgid) != 0 && info.Mode()&os.ModeSymlink == 0 {
+			return os.Chmod(p, info.Mode())
+		}
+		return nil
+	})
+}
+
+// RemapRootURL userns-remap的容器不使用 RootURL，每个id映射使用自己的 ${RemapRootURL}/${uid}.${gid}/，
+// 下面的布局和 RootURL 相同。容器的init进程以映射后的root进入挂载点，RootURL(/root/)通常不允许其他用户进入，
+// 所以和docker的 /var/lib/docker/${uid}.${gid} 一样放在一个0711的目录下
+var RemapRootURL = "/var/lib/ddocker/"
+
+// RemapRoot 返回id映射使用的目录 ${RemapRootURL}/${uid}.${gid}
+func RemapRoot(idmap *IDMapping) string {
+	uid, gid := idmap.RootPair()
+	return filepath.Join(RemapRootURL, fmt.Sprintf("%d.%d", uid, gid))
+}
+
+// RemapRoots 返回 RemapRootURL 下已经存在的所有id映射的目录
+func RemapRoots() []string {
+	matches, _ := filepath.Glob(filepath.Join(RemapRootURL, "*.*"))
+	var roots []string
+	for _, m := range matches {
+		var uid, gid int
+		if n, _ := fmt.Sscanf(filepath.Base(m), "%d.%d", &uid, &gid); n == 2 && filepath.Base(m) == fmt.Sprintf("%d.%d", uid, gid) {
+			roots = append(roots, m)
+		}
+	}
+	return roots
+}
+
+// PrepareRemapRoot 创建id映射使用的目录。RemapRootURL 不存在时创建为0711，每个映射的目录属于root和映射后root的组，
+// 权限是0710，宿主机上的其他用户不能进入。不会修改已经存在的目录的权限，
+// 映射后的root进入不了 RemapRootURL 时返回错误
+func PrepareRemapRoot(idmap *IDMapping) (string, error) {
+	base := filepath.Clean(RemapRootURL)
+	if _, err := os.Stat(base); os.IsNotExist(err) {
+		if err := os.MkdirAll(base, 0711); err != nil {
+			return "", err
+		}
+		// MkdirAll 创建的权限受umask影响
+		if err := os.Chmod(base, 0711); err != nil {
+			return "", err
+		}
+	}
+	for d := base; ; d = filepath.Dir(d) {
+		info, err := os.Stat(d)
+		if err != nil {
+			return "", err
+		}
+		if info.Mode().Perm()&0001 == 0 {
+			return "", fmt.Errorf("%s is not searchable by other users (o+x), the remapped root cannot reach %s", d, base)
+		}
+		if d == "/" {
+			break
+		}
+	}
+
+	root := RemapRoot(idmap)
+	if _, err := os.Stat(root); err == nil {
+		return root, nil
+	}
+	_, gid := idmap.RootPair()
+	if err := os.Mkdir(root, 0710); err != nil && !os.IsExist(err) {
+		return "", err
+	}
+	if err := os.Chown(root, 0, gid); err != nil {
+		return "", err
+	}
+	return root, os.Chmod(root, 0710)
+}
@@ -0,0 +1,45 @@
+package container
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseSubIDs(t *testing.T) {
+	subuid := `
+# comment
+ddocker:100000:65536
+other:200000:65536
+1000:300000:1000
+ddocker:400000:10
+`
+	maps, err := parseSubIDs(strings.NewReader(subuid), []string{"ddocker", "1000"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []IDMap{
+		{ContainerID: 0, HostID: 100000, Size: 65536},
+		{ContainerID: 65536, HostID: 300000, Size: 1000},
+		{ContainerID: 66536, HostID: 400000, Size: 10},
+	}
+	if len(maps) != len(want) {
+		t.Fatalf("got %v, want %v", maps, want)
+	}
+	for i := range want {
+		if maps[i] != want[i] {
+			t.Errorf("map %d: got %v, want %v", i, maps[i], want[i])
+		}
+	}
+
+	if id, ok := toHost(maps, 65537); !ok || id != 300001 {
+		t.Errorf("toHost(65537) = %d %v, want 300001", id, ok)
+	}
+	if _, ok := toHost(maps, 66546); ok {
+		t.Errorf("toHost(66546) should be out of the mapping")
+	}
+
+	if _, err := parseSubIDs(strings.NewReader("ddocker:abc:10\n"), []string{"ddocker"}); err == nil {
+		t.Errorf("expected error for invalid start id")
+	}
+}
@@ -5,29 +5,180 @@ import (
 	"os"
 	"os/exec"
 	"path"
+	"path/filepath"
 	"strings"
+	"syscall"
 
 	"github.com/sirupsen/logrus"
 )
 
 // NewWorkSpace creates a workspace
-func NewWorkSpace(containerID, volume, image string) {
-	roLayer := createReadOnlyLayer(image)   // /root/${image}/
-	wLayer := createWriteLayer(containerID) // /root/writeLayer/${containerID}/
-	mnt := fmt.Sprintf(MntURL, containerID) // /root/mnt/${containerID}/
+// 指定了idmap时容器运行在user namespace中，镜像层使用属主平移过的副本，容器的目录都在id映射的目录
+// ${RemapRootURL}/${uid}.${gid}/ 下，写入层和挂载点的属主改成映射后的root，容器内的root才能写入。
+// rootless模式下宿主机上不能挂载，返回需要容器init进程挂载的文件系统
+func NewWorkSpace(containerID, volume, image string, idmap *IDMapping) []Mount {
+	roLayer := createReadOnlyLayer(image) // /root/${image}/
+	layout := LayoutOf(idmap)
+	mnt := fmt.Sprintf(layout.Mnt, containerID) // /root/mnt/${containerID}/
+
+	if Rootless() {
+		wLayer := createWriteLayer(layout, containerID)
+		return rootlessMounts(roLayer, wLayer, workDir(layout, containerID), mnt, volume)
+	}
+
+	remapped := idmap != nil
+	if remapped {
+		if _, err := PrepareRemapRoot(idmap); err != nil {
+			logrus.Errorf("prepare remap root error: %v", err)
+			return nil
+		}
+		roLayer = createRemappedLayer(roLayer, image, idmap) // ${RemapRootURL}/${uid}.${gid}/${image}/
+	}
+
+	wLayer := createWriteLayer(layout, containerID) // /root/writeLayer/${containerID}/
+	if remapped {
+		chownToRoot(wLayer, idmap)
+	}
 
 	if roLayer != "" && wLayer != "" {
-		createMountPoint(roLayer, wLayer, mnt)
+		createMountPoint(roLayer, wLayer, workDir(layout, containerID), mnt)
 	}
 
 	if volume != "" {
 		volumeURLs := strings.Split(volume, ":")
 		if len(volumeURLs) == 2 && volumeURLs[0] != "" && volumeURLs[1] != "" {
-			mountVolume(mnt, volumeURLs)
-			return
+			mountVolume(mnt, volumeURLs, workDir(layout, containerID+"-volume"), idmap)
+			return nil
 		}
 		logrus.Errorf("bad volume: %v", volume)
 	}
+	return nil
+}
+
+// WorkspaceLayout 容器读写层、overlay workdir和挂载点的路径模板，%s 是容器ID
+type WorkspaceLayout struct {
+	Mnt        string
+	WriteLayer string
+	Work       string
+}
+
+// LayoutOf 返回容器使用的目录布局，userns-remap的容器使用id映射的目录，rootless模式下只有 RootURL 一种布局
+func LayoutOf(idmap *IDMapping) WorkspaceLayout {
+	if idmap == nil || Rootless() {
+		return WorkspaceLayout{Mnt: MntURL, WriteLayer: WriteLayerURL, Work: WorkDirURL}
+	}
+	return remapLayout(RemapRoot(idmap))
+}
+
+func remapLayout(root string) WorkspaceLayout {
+	return WorkspaceLayout{
+		Mnt:        filepath.Join(root, "mnt") + "/%s",
+		WriteLayer: filepath.Join(root, "writeLayer") + "/%s",
+		Work:       filepath.Join(root, "work") + "/%s",
+	}
+}
+
+// WorkspaceLayouts 返回所有可能有容器目录的布局，RootURL 下的和每个id映射目录下的
+func WorkspaceLayouts() []WorkspaceLayout {
+	layouts := []WorkspaceLayout{LayoutOf(nil)}
+	if Rootless() {
+		return layouts
+	}
+	for _, root := range RemapRoots() {
+		layouts = append(layouts, remapLayout(root))
+	}
+	return layouts
+}
+
+// findLayout 返回容器的目录所在的布局，都找不到时返回 RootURL 下的布局
+func findLayout(containerID string) WorkspaceLayout {
+	for _, layout := range WorkspaceLayouts() {
+		for _, tmpl := range []string{layout.Mnt, layout.WriteLayer, layout.Work} {
+			if exist, _ := pathExist(fmt.Sprintf(tmpl, containerID)); exist {
+				return layout
+			}
+		}
+	}
+	return LayoutOf(nil)
+}
+
+// MountPoint 返回容器rootfs的挂载点
+func MountPoint(containerID string, idmap *IDMapping) string {
+	return fmt.Sprintf(LayoutOf(idmap).Mnt, containerID)
+}
+
+// rootlessMounts rootless模式下由容器init进程挂载的rootfs和数据卷。
+// 普通用户在user namespace中挂载overlay需要userxattr选项(Linux 5.11+)，数据卷直接bind挂载宿主机目录
+func rootlessMounts(roLayer, wLayer, work, mnt, volume string) []Mount {
+	if err := os.MkdirAll(mnt, 0755); err != nil {
+		logrus.Errorf("mkdir %v error: %v", mnt, err)
+	}
+
+	mounts := []Mount{{
+		Source: "overlay",
+		Type:   "overlay",
+		Data:   fmt.Sprintf("lowerdir=%s,upperdir=%s,workdir=%s,userxattr", roLayer, wLayer, work),
+	}}
+
+	if volume != "" {
+		volumeURLs := strings.Split(volume, ":")
+		if len(volumeURLs) != 2 || volumeURLs[0] == "" || volumeURLs[1] == "" {
+			logrus.Errorf("bad volume: %v", volume)
+			return mounts
+		}
+		if err := os.MkdirAll(volumeURLs[0], 0755); err != nil {
+			logrus.Errorf("mkdir parent dir %v error: %v", volumeURLs[0], err)
+		}
+		mounts = append(mounts, Mount{
+			Source: volumeURLs[0],
+			Target: volumeURLs[1],
+			Type:   "bind",
+			Flags:  syscall.MS_BIND | syscall.MS_REC,
+		})
+	}
+	return mounts
+}
+
+// ImageLayer 返回容器使用的镜像只读层目录
+func ImageLayer(image string, idmap *IDMapping) string {
+	if idmap == nil || Rootless() {
+		return path.Join(RootURL, image)
+	}
+	return path.Join(RemapRoot(idmap), image)
+}
+
+// createRemappedLayer 复制一份镜像层并把属主平移到映射后的id，同一个映射的容器共用这份副本
+func createRemappedLayer(roLayer, image string, idmap *IDMapping) string {
+	remapped := ImageLayer(image, idmap)
+	if exist, _ := pathExist(remapped); exist {
+		return remapped
+	}
+
+	// 先复制到临时目录，平移完成后再改名，避免中途失败留下属主不完整的镜像层
+	tmp := remapped + ".tmp"
+	_ = os.RemoveAll(tmp)
+	if out, err := exec.Command("cp", "-a", roLayer, tmp).CombinedOutput(); err != nil {
+		logrus.Errorf("copy %v to %v error: %v %s", roLayer, tmp, err, out)
+		return ""
+	}
+	if err := idmap.shiftOwnership(tmp); err != nil {
+		logrus.Errorf("shift owner of %v error: %v", tmp, err)
+		_ = os.RemoveAll(tmp)
+		return ""
+	}
+	if err := os.Rename(tmp, remapped); err != nil {
+		logrus.Errorf("rename %v error: %v", tmp, err)
+		return ""
+	}
+	return remapped
+}
+
+// chownToRoot 把ddocker自己创建的目录的属主改成容器内root在宿主机上对应的id
+func chownToRoot(dir string, idmap *IDMapping) {
+	uid, gid := idmap.RootPair()
+	if err := os.Lchown(dir, uid, gid); err != nil {
+		logrus.Errorf("chown %v to %d:%d error: %v", dir, uid, gid, err)
+	}
 }
 
 func createReadOnlyLayer(image string) string {
@@ -45,7 +196,7 @@ func createReadOnlyLayer(image string) string {
 	}
 
 	if exist {
-		if err := os.MkdirAll(roLayer, 0622); err != nil {
+		if err := os.MkdirAll(roLayer, 0755); err != nil {
 			logrus.Errorf("mkdir %v error: %v", roLayer, err)
 		}
 
@@ -57,9 +208,9 @@ func createReadOnlyLayer(image string) string {
 	return roLayer
 }
 
-func createWriteLayer(containerID string) string {
+func createWriteLayer(layout WorkspaceLayout, containerID string) string {
 	// "/root/writeLayer/${containerID}"
-	wLayer := fmt.Sprintf(WriteLayerURL, containerID)
+	wLayer := fmt.Sprintf(layout.WriteLayer, containerID)
 
 	if exist, _ := pathExist(wLayer); !exist {
 		if err := os.MkdirAll(wLayer, 0777); err != nil {
@@ -67,16 +218,20 @@ func createWriteLayer(containerID string) string {
 		}
 	}
 
-	// work是overlay必须的，具体为什么？？？？ 暂时放这里吧
-	if exist, _ := pathExist(WorkDirURL); !exist {
-		if err := os.MkdirAll(WorkDirURL, 0777); err != nil {
-			logrus.Errorf("mkdir %v error: %v", WorkDirURL, err)
-		}
-	}
 	return wLayer
 }
 
-func createMountPoint(roLayer, wLayer, mnt string) {
+// workDir 创建overlay的workdir "/root/work/${name}"，overlay在这里准备copy-up的文件，
+// 必须和upperdir在同一个文件系统上，并且不能被其他overlay挂载共用
+func workDir(layout WorkspaceLayout, name string) string {
+	work := fmt.Sprintf(layout.Work, name)
+	if err := os.MkdirAll(work, 0755); err != nil {
+		logrus.Errorf("mkdir %v error: %v", work, err)
+	}
+	return work
+}
+
+func createMountPoint(roLayer, wLayer, work, mnt string) {
 	if exist, _ := pathExist(mnt); !exist {
 		if err := os.MkdirAll(mnt, 0777); err != nil {
 			logrus.Errorf("mkdir %v error: %v", mnt, err)
@@ -87,7 +242,7 @@ func createMountPoint(roLayer, wLayer, mnt string) {
 	// cat /proc/filesystems 查看支持的文件系统类型
 	//
 	// mount -t overlay overlay -o lowerdir=./lower,upperdir=./upper,workdir=./work ./merged
-	dirs := fmt.Sprintf("lowerdir=%s,upperdir=%s,workdir=%s", roLayer, wLayer, WorkDirURL)
+	dirs := fmt.Sprintf("lowerdir=%s,upperdir=%s,workdir=%s", roLayer, wLayer, work)
 	cmd := exec.Command("mount", "-t", "overlay", "overlay", "-o", dirs, mnt)
 	cmd.Stdout = os.Stdout
 	cmd.Stderr = os.Stderr
@@ -96,6 +251,16 @@ func createMountPoint(roLayer, wLayer, mnt string) {
 	}
 }
 
+// ownedBy 判断文件的属主是否是uid:gid
+func ownedBy(file string, uid, gid int) bool {
+	info, err := os.Stat(file)
+	if err != nil {
+		return false
+	}
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	return ok && int(stat.Uid) == uid && int(stat.Gid) == gid
+}
+
 func pathExist(path string) (bool, error) {
 	_, err := os.Stat(path)
 	if err == nil {
@@ -108,13 +273,24 @@ func pathExist(path string) (bool, error) {
 	return false, err
 }
 
-// mountVolume 对用户要挂载进来的路径进行挂载
-func mountVolume(mnt string, volumeURLs []string) {
+// mountVolume 对用户要挂载进来的路径进行挂载。
+// 指定了idmap时只修改ddocker新创建的宿主机目录的属主，不会修改用户已有的目录，
+// 这时要由用户保证映射后的root可以写这个目录
+func mountVolume(mnt string, volumeURLs []string, work string, idmap *IDMapping) {
 	// 创建宿主机文件目录
 	parentURL := volumeURLs[0]
+	existed, _ := pathExist(parentURL)
 	if err := os.MkdirAll(parentURL, 0777); err != nil {
 		logrus.Errorf("mkdir parent dir %v error: %v", parentURL, err)
 	}
+	if idmap != nil {
+		if !existed {
+			chownToRoot(parentURL, idmap)
+		} else if uid, gid := idmap.RootPair(); !ownedBy(parentURL, uid, gid) {
+			logrus.Warnf("volume %v is not owned by the remapped root %d:%d and is not changed, "+
+				"the container root may not be able to write it", parentURL, uid, gid)
+		}
+	}
 
 	// 在容器目录创建挂载点目录
 	containerURL := path.Join(mnt, volumeURLs[1])
@@ -123,7 +299,7 @@ func mountVolume(mnt string, volumeURLs []string) {
 	}
 
 	// 把宿主机文件目录挂在到容器内挂载点
-	dirs := fmt.Sprintf("lowerdir=%s,upperdir=%s,workdir=%s", containerURL, parentURL, WorkDirURL)
+	dirs := fmt.Sprintf("lowerdir=%s,upperdir=%s,workdir=%s", containerURL, parentURL, work)
 	cmd := exec.Command("mount", "-t", "overlay", "overlay", "-o", dirs, containerURL)
 	cmd.Stdout = os.Stdout
 	cmd.Stderr = os.Stderr
@@ -134,8 +310,17 @@ func mountVolume(mnt string, volumeURLs []string) {
 
 // DeleteWorkSpace .
 func DeleteWorkSpace(containerID, volume string) {
-	wLayer := fmt.Sprintf(WriteLayerURL, containerID) // /root/writeLayer/${containerID}/
-	mnt := fmt.Sprintf(MntURL, containerID)           // /root/mnt/${containerID}/
+	layout := findLayout(containerID)
+	wLayer := fmt.Sprintf(layout.WriteLayer, containerID) // /root/writeLayer/${containerID}/
+	mnt := fmt.Sprintf(layout.Mnt, containerID)           // /root/mnt/${containerID}/
+	defer deleteWorkDir(layout, containerID)
+
+	// stop时已经删除过的工作空间不再重复卸载，rootless模式下挂载在容器自己的mount namespace中，随容器一起消失
+	if exist, _ := pathExist(mnt); !exist || Rootless() {
+		_ = os.RemoveAll(mnt)
+		deleteWritePlayer(wLayer)
+		return
+	}
 
 	if volume != "" {
 		volumeURLs := strings.Split(volume, ":")
@@ -184,10 +369,14 @@ func deleteWritePlayer(wLayer string) {
 	if err := os.RemoveAll(wLayer); err != nil {
 		logrus.Errorf("remove dir %v error: %v", wLayer, err)
 	}
+}
 
-	// work是overlay必须的，具体为什么？？？？
-	// workURL := rootURL + "work/"
-	// if err := os.RemoveAll(workURL); err != nil {
-	// 	logrus.Errorf("remove dir %v error: %v", workURL, err)
-	// }
+// deleteWorkDir 删除容器rootfs和数据卷的overlay workdir
+func deleteWorkDir(layout WorkspaceLayout, containerID string) {
+	for _, name := range []string{containerID, containerID + "-volume"} {
+		work := fmt.Sprintf(layout.Work, name)
+		if err := os.RemoveAll(work); err != nil {
+			logrus.Errorf("remove dir %v error: %v", work, err)
+		}
+	}
 }
@@ -0,0 +1,204 @@
+package logger
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/devhg/ddocker/util"
+)
+
+const (
+	JSONFileDriver = "json-file"
+	SyslogDriver   = "syslog"
+	NoneDriver     = "none"
+)
+
+// LogDriver 日志驱动，监控进程把容器输出的每一行交给驱动处理
+type LogDriver interface {
+	Name() string
+
+	// 记录一条日志
+	Log(e *Entry) error
+
+	Close() error
+}
+
+// Config 是run命令 --log-driver 和 --log-opt 指定的日志配置
+type Config struct {
+	Driver string
+
+	// json-file: 单个日志文件的最大字节数，0表示不滚动
+	MaxSize int64
+	// json-file: 最多保留的日志文件个数，包括正在写入的文件
+	MaxFiles int
+
+	// syslog: 日志发送的地址，例如 unix:///dev/log、udp://127.0.0.1:514
+	SyslogAddress string
+	// syslog: 消息的APP-NAME，默认是容器ID
+	Tag string
+}
+
+// driverOpts 每种驱动支持的 --log-opt
+var driverOpts = map[string][]string{
+	JSONFileDriver: {"max-size", "max-file"},
+	SyslogDriver:   {"syslog-address", "tag"},
+	NoneDriver:     nil,
+}
+
+// ParseConfig 解析日志驱动和它的选项，例如
+// --log-driver json-file --log-opt max-size=10m --log-opt max-file=3
+func ParseConfig(driver string, opts []string) (*Config, error) {
+	if driver == "" {
+		driver = JSONFileDriver
+	}
+	allowed, ok := driverOpts[driver]
+	if !ok {
+		return nil, fmt.Errorf("unknown log driver %q", driver)
+	}
+
+	config := &Config{
+		Driver:        driver,
+		MaxFiles:      1,
+		SyslogAddress: "unix:///dev/log",
+	}
+	for _, opt := range opts {
+		kv := strings.SplitN(opt, "=", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("invalid log opt %q, expect key=value", opt)
+		}
+		if !contains(allowed, kv[0]) {
+			return nil, fmt.Errorf("unknown log opt %q for log driver %s", kv[0], driver)
+		}
+
+		switch kv[0] {
+		case "max-size":
+			size, err := util.ParseSize(kv[1])
+			if err != nil || size <= 0 {
+				return nil, fmt.Errorf("invalid max-size %q", kv[1])
+			}
+			config.MaxSize = size
+		case "max-file":
+			n, err := strconv.Atoi(kv[1])
+			if err != nil || n < 1 {
+				return nil, fmt.Errorf("invalid max-file %q, expect a positive number", kv[1])
+			}
+			config.MaxFiles = n
+		case "syslog-address":
+			if _, _, err := parseSyslogAddress(kv[1]); err != nil {
+				return nil, err
+			}
+			config.SyslogAddress = kv[1]
+		case "tag":
+			if err := validateSyslogTag(kv[1]); err != nil {
+				return nil, err
+			}
+			config.Tag = kv[1]
+		}
+	}
+
+	if config.MaxFiles > 1 && config.MaxSize == 0 {
+		return nil, fmt.Errorf("max-file requires max-size to be set")
+	}
+	return config, nil
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+// Info 创建日志驱动需要的容器信息
+type Info struct {
+	ContainerID string
+	// json-file 写入的日志文件
+	LogPath string
+	Config  *Config
+}
+
+// New 根据配置创建容器的日志驱动
+func New(info *Info) (LogDriver, error) {
+	switch info.Config.Driver {
+	case JSONFileDriver:
+		f, err := NewRotateFile(info.LogPath, info.Config)
+		if err != nil {
+			return nil, err
+		}
+		return NewJSONFile(f), nil
+	case SyslogDriver:
+		return newSyslogDriver(info)
+	case NoneDriver:
+		return &noneDriver{}, nil
+	}
+	return nil, fmt.Errorf("unknown log driver %q", info.Config.Driver)
+}
+
+// noneDriver 丢弃所有日志
+type noneDriver struct {
+}
+
+func (n *noneDriver) Name() string {
+	return NoneDriver
+}
+
+func (n *noneDriver) Log(e *Entry) error {
+	return nil
+}
+
+func (n *noneDriver) Close() error {
+	return nil
+}
+
+// NewLineWriter 返回写入某个stream的io.Writer，写入的内容按行切分成日志记录交给driver
+func NewLineWriter(driver LogDriver, stream string) *LineWriter {
+	return &LineWriter{stream: stream, driver: driver}
+}
+
+// LineWriter 缓存没有换行的输出，直到读到换行、超过maxLineSize或者Flush时才写成一条记录
+type LineWriter struct {
+	stream string
+	driver LogDriver
+	buf    []byte
+}
+
+func (l *LineWriter) Write(p []byte) (int, error) {
+	l.buf = append(l.buf, p...)
+	for {
+		i := bytes.IndexByte(l.buf, '\n')
+		if i < 0 {
+			if len(l.buf) < maxLineSize {
+				return len(p), nil
+			}
+			i = maxLineSize - 1
+		}
+
+		if err := l.emit(l.buf[:i+1]); err != nil {
+			return len(p), err
+		}
+		l.buf = l.buf[i+1:]
+	}
+}
+
+// Flush 把缓存中最后不完整的一行写成一条记录
+func (l *LineWriter) Flush() error {
+	if len(l.buf) == 0 {
+		return nil
+	}
+	err := l.emit(l.buf)
+	l.buf = nil
+	return err
+}
+
+func (l *LineWriter) emit(line []byte) error {
+	return l.driver.Log(&Entry{
+		Stream: l.stream,
+		Time:   time.Now(),
+		Log:    string(line),
+	})
+}
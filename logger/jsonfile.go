@@ -0,0 +1,86 @@
+package logger
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io"
+	"sync"
+	"time"
+)
+
+const (
+	Stdout = "stdout"
+	Stderr = "stderr"
+)
+
+// maxLineSize 单条日志记录的最大长度，超过的行会被切分成多条记录
+const maxLineSize = 16 * 1024
+
+// Entry 是json-file格式日志中的一条记录，每条记录占一行，例如
+// {"stream":"stdout","time":"2021-08-07T16:01:34.123456789+08:00","log":"hello\n"}
+type Entry struct {
+	Stream string    `json:"stream"`
+	Time   time.Time `json:"time"`
+	Log    string    `json:"log"`
+}
+
+// JSONFile 是json-file日志驱动，把容器的输出写成json-file格式的日志，stdout和stderr共用同一个JSONFile
+type JSONFile struct {
+	mu sync.Mutex
+	w  io.WriteCloser
+}
+
+func NewJSONFile(w io.WriteCloser) *JSONFile {
+	return &JSONFile{w: w}
+}
+
+func (j *JSONFile) Name() string {
+	return JSONFileDriver
+}
+
+// Log 写入一条日志记录
+func (j *JSONFile) Log(e *Entry) error {
+	b, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	b = append(b, '\n')
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	_, err = j.w.Write(b)
+	return err
+}
+
+func (j *JSONFile) Close() error {
+	return j.w.Close()
+}
+
+// ReadEntries 逐行解析json-file格式的日志
+func ReadEntries(r io.Reader, fn func(e *Entry) error) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		if len(line) == 0 {
+			continue
+		}
+
+		if err := fn(parseEntry(line)); err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
+
+// parseEntry 解析一行日志记录，不是json的行(旧版本写入的纯文本日志)当作stdout的输出
+func parseEntry(line []byte) *Entry {
+	line = bytes.TrimSuffix(line, []byte{'\n'})
+
+	e := &Entry{}
+	if err := json.Unmarshal(line, e); err != nil || e.Stream == "" {
+		e = &Entry{Stream: Stdout, Log: string(line) + "\n"}
+	}
+	return e
+}
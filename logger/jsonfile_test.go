@@ -0,0 +1,61 @@
+package logger
+
+import (
+	"bytes"
+	"testing"
+)
+
+type nopCloser struct {
+	*bytes.Buffer
+}
+
+func (nopCloser) Close() error { return nil }
+
+func TestLineWriter(t *testing.T) {
+	buf := nopCloser{&bytes.Buffer{}}
+	j := NewJSONFile(buf)
+
+	stdout := NewLineWriter(j, Stdout)
+	stderr := NewLineWriter(j, Stderr)
+	_, _ = stdout.Write([]byte("hello\nwor"))
+	_, _ = stderr.Write([]byte("oops\n"))
+	_, _ = stdout.Write([]byte("ld\nno newline"))
+	_ = stdout.Flush()
+
+	var got []Entry
+	err := ReadEntries(buf, func(e *Entry) error {
+		got = append(got, *e)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []Entry{
+		{Stream: Stdout, Log: "hello\n"},
+		{Stream: Stderr, Log: "oops\n"},
+		{Stream: Stdout, Log: "world\n"},
+		{Stream: Stdout, Log: "no newline"},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("got %d entries, want %d", len(got), len(want))
+	}
+	for i := range want {
+		if got[i].Stream != want[i].Stream || got[i].Log != want[i].Log || got[i].Time.IsZero() {
+			t.Errorf("entry %d: got %+v, want %+v", i, got[i], want[i])
+		}
+	}
+}
+
+func TestReadEntriesPlainText(t *testing.T) {
+	buf := bytes.NewBufferString("plain line\n")
+	err := ReadEntries(buf, func(e *Entry) error {
+		if e.Stream != Stdout || e.Log != "plain line\n" {
+			t.Errorf("unexpected entry %+v", e)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,211 @@
+package logger
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"os"
+	"time"
+)
+
+// followInterval follow模式下读到文件末尾后，等待新日志的轮询间隔
+const followInterval = 200 * time.Millisecond
+
+// errStopRead 读到Until之后的记录时结束读取
+var errStopRead = errors.New("stop reading log")
+
+// ReadConfig 读取日志的选项
+type ReadConfig struct {
+	// 只读取最后Tail行，小于0表示读取全部
+	Tail int
+	// 只输出[Since, Until]之间的记录，零值表示不限制
+	Since time.Time
+	Until time.Time
+	// Follow 读到文件末尾后继续等待新的日志，直到Stopped返回true
+	Follow  bool
+	Stopped func() bool
+}
+
+// ReadLog 按照config读取json-file格式的日志，包括滚动出来的旧文件，按时间顺序
+// 每条满足条件的记录调用一次fn
+func ReadLog(file string, config *ReadConfig, fn func(e *Entry) error) error {
+	files := rotatedFiles(file)
+	if len(files) == 0 {
+		return os.ErrNotExist
+	}
+
+	// 从最新的文件往前找，确定最后Tail行从哪个文件的哪个位置开始
+	offsets := make([]int64, len(files))
+	if config.Tail >= 0 {
+		remaining := config.Tail
+		start := len(files) - 1
+		for i := len(files) - 1; i >= 0; i-- {
+			offset, count, err := tailOffset(files[i], remaining)
+			if err != nil {
+				return err
+			}
+			offsets[i] = offset
+			start = i
+			if remaining -= count; remaining == 0 {
+				break
+			}
+		}
+		files, offsets = files[start:], offsets[start:]
+	}
+
+	handle := func(e *Entry) error {
+		if !config.Until.IsZero() && e.Time.After(config.Until) {
+			return errStopRead
+		}
+		if !config.Since.IsZero() && e.Time.Before(config.Since) {
+			return nil
+		}
+		return fn(e)
+	}
+
+	for i, f := range files {
+		// 只有正在写入的文件需要follow
+		var err error
+		if i == len(files)-1 && config.Follow {
+			err = followLogFile(f, offsets[i], config.Stopped, handle)
+		} else {
+			err = readLogFile(f, offsets[i], handle)
+		}
+
+		if err == errStopRead {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// readLogFile 从offset开始读取一个日志文件
+func readLogFile(file string, offset int64, handle func(e *Entry) error) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		return err
+	}
+
+	return ReadEntries(f, handle)
+}
+
+// followLogFile 从offset开始读取正在写入的日志文件，读到末尾后等待新的日志。
+// 日志文件滚动后切换到新的文件继续读，stopped返回true(容器已经退出)后结束
+func followLogFile(file string, offset int64, stopped func() bool, handle func(e *Entry) error) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer func() { f.Close() }()
+
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		return err
+	}
+
+	r := bufio.NewReader(f)
+	var partial []byte
+	stopping, rotated := false, false
+	for {
+		line, err := r.ReadBytes('\n')
+		partial = append(partial, line...)
+
+		// 只处理完整的一行，不完整的行可能还在写入中
+		if len(partial) > 0 && partial[len(partial)-1] == '\n' {
+			e := parseEntry(partial)
+			partial = nil
+			if err := handle(e); err != nil {
+				return err
+			}
+		}
+
+		if err == nil {
+			continue
+		}
+		if err != io.EOF {
+			return err
+		}
+
+		// 检查到滚动之后，旧文件已经读完了，切换到新的文件
+		if rotated {
+			newFile, err := os.Open(file)
+			if err != nil {
+				return err
+			}
+			f.Close()
+			f, r, partial, rotated = newFile, bufio.NewReader(newFile), nil, false
+			continue
+		}
+
+		// 容器退出后再读一遍，保证退出前最后写入的日志也能输出
+		if stopping {
+			return nil
+		}
+		if stopped != nil && stopped() {
+			stopping = true
+			continue
+		}
+
+		// 文件被滚动后，路径指向的是新文件，先把旧文件剩下的内容读完
+		if cur, err := os.Stat(file); err == nil {
+			if old, err := f.Stat(); err == nil && !os.SameFile(cur, old) {
+				rotated = true
+				continue
+			}
+		}
+		time.Sleep(followInterval)
+	}
+}
+
+// tailOffset 从文件末尾往前查找最后n行开始的偏移，不需要读取整个文件。
+// 文件不足n行时返回0和文件的实际行数
+func tailOffset(file string, n int) (int64, int, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer f.Close()
+
+	size, err := f.Seek(0, io.SeekEnd)
+	if err != nil || n == 0 {
+		return size, 0, err
+	}
+
+	buf := make([]byte, 4096)
+	pos := size
+	count := 0
+	for pos > 0 {
+		readSize := int64(len(buf))
+		if pos < readSize {
+			readSize = pos
+		}
+		pos -= readSize
+
+		if _, err := f.ReadAt(buf[:readSize], pos); err != nil {
+			return 0, 0, err
+		}
+		for i := readSize - 1; i >= 0; i-- {
+			// 文件最后的换行是最后一行的结尾，不是新一行的开始
+			if buf[i] != '\n' || pos+i == size-1 {
+				continue
+			}
+			count++
+			if count == n {
+				return pos + i + 1, n, nil
+			}
+		}
+	}
+
+	// 已经到了文件开头，第一行前面没有换行
+	if size > 0 {
+		count++
+	}
+	return 0, count, nil
+}
@@ -0,0 +1,79 @@
+package logger
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func writeTestLog(t *testing.T, lines ...string) string {
+	dir, err := ioutil.TempDir("", "ddocker-log")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	file := filepath.Join(dir, "std.log")
+	f, err := os.Create(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	j := NewJSONFile(f)
+	base := time.Date(2021, 8, 7, 16, 0, 0, 0, time.UTC)
+	for i, line := range lines {
+		if err := j.Log(&Entry{Stream: Stdout, Time: base.Add(time.Duration(i) * time.Minute), Log: line + "\n"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	j.Close()
+	return file
+}
+
+func readAll(t *testing.T, file string, config *ReadConfig) string {
+	var logs []string
+	err := ReadLog(file, config, func(e *Entry) error {
+		logs = append(logs, strings.TrimSuffix(e.Log, "\n"))
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return strings.Join(logs, ",")
+}
+
+func TestReadLogTail(t *testing.T) {
+	file := writeTestLog(t, "a", "b", "c", "d")
+
+	tests := []struct {
+		tail int
+		want string
+	}{
+		{-1, "a,b,c,d"},
+		{0, ""},
+		{2, "c,d"},
+		{4, "a,b,c,d"},
+		{10, "a,b,c,d"},
+	}
+	for _, tt := range tests {
+		if got := readAll(t, file, &ReadConfig{Tail: tt.tail}); got != tt.want {
+			t.Errorf("tail %d: got %q, want %q", tt.tail, got, tt.want)
+		}
+	}
+}
+
+func TestReadLogSinceUntil(t *testing.T) {
+	file := writeTestLog(t, "a", "b", "c", "d")
+	base := time.Date(2021, 8, 7, 16, 0, 0, 0, time.UTC)
+
+	got := readAll(t, file, &ReadConfig{
+		Tail:  -1,
+		Since: base.Add(time.Minute),
+		Until: base.Add(2 * time.Minute),
+	})
+	if got != "b,c" {
+		t.Errorf("got %q, want %q", got, "b,c")
+	}
+}
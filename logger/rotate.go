@@ -0,0 +1,107 @@
+package logger
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// RotateFile 是按大小滚动的日志文件。写满MaxSize之后，std.log 重命名为 std.log.1，
+// 原来的 std.log.1 重命名为 std.log.2，依次类推，最多保留MaxFiles个文件。
+// 每次Write都是完整的一条记录，所以滚动不会把一条记录拆到两个文件里
+type RotateFile struct {
+	mu     sync.Mutex
+	path   string
+	config *Config
+	f      *os.File
+	size   int64
+}
+
+// NewRotateFile 创建(清空)日志文件，并删除以前留下的滚动文件
+func NewRotateFile(path string, config *Config) (*RotateFile, error) {
+	for _, old := range rotatedFiles(path) {
+		if old != path {
+			_ = os.Remove(old)
+		}
+	}
+
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	return &RotateFile{path: path, config: config, f: f}, nil
+}
+
+func (r *RotateFile) Write(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.config.MaxSize > 0 && r.size > 0 && r.size+int64(len(p)) > r.config.MaxSize {
+		if err := r.rotate(); err != nil {
+			return 0, err
+		}
+	}
+
+	n, err := r.f.Write(p)
+	r.size += int64(n)
+	return n, err
+}
+
+func (r *RotateFile) rotate() error {
+	if err := r.f.Close(); err != nil {
+		return err
+	}
+
+	if r.config.MaxFiles > 1 {
+		// std.log.${N-1} -> std.log.${N} ... std.log -> std.log.1，最老的文件被覆盖
+		for i := r.config.MaxFiles - 1; i > 1; i-- {
+			_ = os.Rename(fmt.Sprintf("%s.%d", r.path, i-1), fmt.Sprintf("%s.%d", r.path, i))
+		}
+		if err := os.Rename(r.path, r.path+".1"); err != nil {
+			return err
+		}
+	}
+
+	f, err := os.Create(r.path)
+	if err != nil {
+		return err
+	}
+	r.f = f
+	r.size = 0
+	return nil
+}
+
+func (r *RotateFile) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.f.Close()
+}
+
+// rotatedFiles 返回日志文件和它滚动出来的文件，按从旧到新排列：
+// [std.log.N, ..., std.log.1, std.log]
+func rotatedFiles(path string) []string {
+	matches, _ := filepath.Glob(path + ".*")
+
+	indexes := make(map[string]int)
+	var files []string
+	for _, m := range matches {
+		i, err := strconv.Atoi(strings.TrimPrefix(m, path+"."))
+		if err != nil || i < 1 {
+			continue
+		}
+		indexes[m] = i
+		files = append(files, m)
+	}
+	sort.Slice(files, func(a, b int) bool {
+		return indexes[files[a]] > indexes[files[b]]
+	})
+
+	if _, err := os.Stat(path); err == nil {
+		files = append(files, path)
+	}
+	return files
+}
@@ -0,0 +1,57 @@
+package logger
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRotateFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ddocker-rotate")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	file := filepath.Join(dir, "std.log")
+	config, err := ParseConfig(JSONFileDriver, []string{"max-size=200", "max-file=3"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rf, err := NewRotateFile(file, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	j := NewJSONFile(rf)
+	w := NewLineWriter(j, Stdout)
+	for i := 0; i < 20; i++ {
+		fmt.Fprintf(w, "line%d\n", i)
+	}
+	j.Close()
+
+	files := rotatedFiles(file)
+	if len(files) != 3 {
+		t.Fatalf("got files %v, want 3 files", files)
+	}
+	for _, f := range files {
+		if info, err := os.Stat(f); err != nil || info.Size() > config.MaxSize {
+			t.Errorf("file %s exceeds max-size: %v %v", f, info.Size(), err)
+		}
+	}
+
+	// 最新的记录一定在，并且跨文件按顺序读取
+	if got := readAll(t, file, &ReadConfig{Tail: 3}); got != "line17,line18,line19" {
+		t.Errorf("tail across files: got %q", got)
+	}
+}
+
+func TestParseConfig(t *testing.T) {
+	for _, opts := range [][]string{{"max-size=0"}, {"max-file=3"}, {"unknown=1"}, {"max-size"}} {
+		if _, err := ParseConfig(JSONFileDriver, opts); err == nil {
+			t.Errorf("expected error for %v", opts)
+		}
+	}
+}
@@ -0,0 +1,133 @@
+package logger
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// syslog的facility，容器日志统一用daemon
+	syslogFacilityDaemon = 3
+	syslogSeverityErr    = 3
+	syslogSeverityInfo   = 6
+)
+
+// syslogDriver 把日志按RFC5424格式发送到syslog地址，
+// stdout的记录使用info级别，stderr的记录使用err级别
+type syslogDriver struct {
+	mu       sync.Mutex
+	conn     net.Conn
+	network  string
+	tag      string
+	hostname string
+}
+
+// parseSyslogAddress 解析 syslog-address，支持 unix、unixgram、udp 和 tcp
+func parseSyslogAddress(address string) (string, string, error) {
+	u, err := url.Parse(address)
+	if err != nil {
+		return "", "", fmt.Errorf("invalid syslog-address %q: %w", address, err)
+	}
+
+	switch u.Scheme {
+	case "unix", "unixgram":
+		if u.Path == "" {
+			return "", "", fmt.Errorf("invalid syslog-address %q, missing socket path", address)
+		}
+		return u.Scheme, u.Path, nil
+	case "udp", "tcp":
+		if _, _, err := net.SplitHostPort(u.Host); err != nil {
+			return "", "", fmt.Errorf("invalid syslog-address %q: %w", address, err)
+		}
+		return u.Scheme, u.Host, nil
+	}
+	return "", "", fmt.Errorf("invalid syslog-address %q, unsupported scheme %q", address, u.Scheme)
+}
+
+// validateSyslogTag 检查tag能否作为RFC5424的APP-NAME：最多48个可打印的ASCII字符，不能有空格。
+// 为空时使用容器ID
+func validateSyslogTag(tag string) error {
+	if len(tag) > 48 {
+		return fmt.Errorf("invalid tag %q, longer than 48 characters", tag)
+	}
+	for _, c := range []byte(tag) {
+		if c < 33 || c > 126 {
+			return fmt.Errorf("invalid tag %q, only printable ASCII characters without spaces are allowed", tag)
+		}
+	}
+	return nil
+}
+
+func newSyslogDriver(info *Info) (*syslogDriver, error) {
+	network, addr, err := parseSyslogAddress(info.Config.SyslogAddress)
+	if err != nil {
+		return nil, err
+	}
+
+	var conn net.Conn
+	if network == "unix" {
+		// /dev/log 一般是unixgram，连不上时再按stream方式连接
+		conn, err = net.Dial("unixgram", addr)
+		if err != nil {
+			conn, err = net.Dial("unix", addr)
+		}
+	} else {
+		conn, err = net.Dial(network, addr)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("connect syslog %s error: %w", info.Config.SyslogAddress, err)
+	}
+
+	tag := info.Config.Tag
+	if tag == "" {
+		tag = info.ContainerID
+	}
+	hostname, _ := os.Hostname()
+	if hostname == "" {
+		hostname = "-"
+	}
+
+	return &syslogDriver{
+		conn:     conn,
+		network:  conn.RemoteAddr().Network(),
+		tag:      tag,
+		hostname: hostname,
+	}, nil
+}
+
+func (s *syslogDriver) Name() string {
+	return SyslogDriver
+}
+
+// Log 发送一条 <PRI>1 TIMESTAMP HOSTNAME APP-NAME PROCID MSGID SD MSG 格式的消息
+func (s *syslogDriver) Log(e *Entry) error {
+	severity := syslogSeverityInfo
+	if e.Stream == Stderr {
+		severity = syslogSeverityErr
+	}
+
+	msg := fmt.Sprintf("<%d>1 %s %s %s - - - %s",
+		syslogFacilityDaemon*8+severity,
+		e.Time.UTC().Format(time.RFC3339Nano),
+		s.hostname, s.tag,
+		strings.TrimSuffix(e.Log, "\n"))
+
+	// 面向流的连接用RFC6587的octet counting分帧
+	if s.network == "tcp" || s.network == "unix" {
+		msg = fmt.Sprintf("%d %s", len(msg), msg)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, err := s.conn.Write([]byte(msg))
+	return err
+}
+
+func (s *syslogDriver) Close() error {
+	return s.conn.Close()
+}
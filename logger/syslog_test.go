@@ -0,0 +1,78 @@
+package logger
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSyslogDriver(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ddocker-syslog")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	sock := filepath.Join(dir, "log.sock")
+	conn, err := net.ListenPacket("unixgram", sock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	config, err := ParseConfig(SyslogDriver, []string{"syslog-address=unixgram://" + sock, "tag=web"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	driver, err := New(&Info{ContainerID: "1234567890", Config: config})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer driver.Close()
+
+	now := time.Now()
+	driver.Log(&Entry{Stream: Stdout, Time: now, Log: "hello\n"})
+	driver.Log(&Entry{Stream: Stderr, Time: now, Log: "oops\n"})
+
+	// daemon(3)*8 + info(6) = 30, daemon(3)*8 + err(3) = 27
+	for _, want := range []string{`^<30>1 \S+ \S+ web - - - hello$`, `^<27>1 \S+ \S+ web - - - oops$`} {
+		buf := make([]byte, 1024)
+		conn.SetReadDeadline(time.Now().Add(time.Second))
+		n, _, err := conn.ReadFrom(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !regexp.MustCompile(want).Match(buf[:n]) {
+			t.Errorf("got %q, want %s", buf[:n], want)
+		}
+	}
+}
+
+func TestParseSyslogConfig(t *testing.T) {
+	for _, opts := range [][]string{
+		{"syslog-address=http://x"},
+		{"syslog-address=udp://nohost"},
+		{"max-size=1m"},
+		{"tag=my app"},
+		{"tag=web\napp"},
+		{"tag=café"},
+		{"tag=" + strings.Repeat("a", 49)},
+	} {
+		if _, err := ParseConfig(SyslogDriver, opts); err == nil {
+			t.Errorf("expected error for %v", opts)
+		}
+	}
+	if _, err := ParseConfig(NoneDriver, []string{"tag=x"}); err == nil {
+		t.Errorf("expected error for none driver with options")
+	}
+	for _, tag := range []string{"", "web", "app/web-1.2_x", strings.Repeat("a", 48)} {
+		if _, err := ParseConfig(SyslogDriver, []string{"tag=" + tag}); err != nil {
+			t.Errorf("tag %q: %v", tag, err)
+		}
+	}
+}
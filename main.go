@@ -7,6 +7,7 @@ import (
 	"github.com/urfave/cli"
 
 	"github.com/devhg/ddocker/cmd"
+	"github.com/devhg/ddocker/container"
 )
 
 const usage = `ddocker is a simple container runtime implementation.
@@ -25,14 +26,20 @@ func main() {
 		cmd.PsCommand,
 		cmd.LogCommand,
 		cmd.ExecCommand,
+		cmd.TopCommand,
+		cmd.AttachCommand,
 		cmd.StopCommand,
 		cmd.RemoveCommand,
 		cmd.NetworkCommand,
+		cmd.ContainerCommand,
+		cmd.ImageCommand,
+		cmd.SystemCommand,
 	}
 
 	app.Before = func(ctx *cli.Context) error {
 		logrus.SetFormatter(&logrus.JSONFormatter{})
 		logrus.SetOutput(os.Stdout)
+		container.SetupRootlessPaths()
 		return nil
 	}
 
@@ -175,6 +175,10 @@ func (b *BridgeNetworkDriver) Delete(network Network) error {
 	bridgeName := network.Name
 	br, err := netlink.LinkByName(bridgeName)
 	if err != nil {
+		// 网桥已经不存在(例如宿主机重启过)，只需要清理网络的配置
+		if _, ok := err.(netlink.LinkNotFoundError); ok {
+			return nil
+		}
 		return err
 	}
 
@@ -215,7 +219,16 @@ func (b *BridgeNetworkDriver) Connect(network *Network, endpoint *Endpoint) erro
 	return nil
 }
 
-// 从网络上移除容器的网络端点
+// Disconnect 从网络上移除容器的网络端点，删除宿主机上的veth设备
+// 容器的net namespace销毁时veth会被内核一起删除，这时就不需要再处理了
 func (b *BridgeNetworkDriver) Disconnect(network Network, endpoint *Endpoint) error {
-	return nil
+	veth, err := netlink.LinkByName(endpoint.ID[:5])
+	if err != nil {
+		if _, ok := err.(netlink.LinkNotFoundError); ok {
+			return nil
+		}
+		return err
+	}
+
+	return netlink.LinkDel(veth)
 }
@@ -133,6 +133,9 @@ func (ia *IPAM) Release(subnet *net.IPNet, ipaddr net.IP) error {
 		logrus.Infof("load IPAM allocation info error: %v", err)
 	}
 
+	// 网络的IPRange中记录的是网关IP，这里统一换算成网段地址
+	_, subnet, _ = net.ParseCIDR(subnet.String())
+
 	releaseIP := ipaddr.To4()
 	subnetIP := subnet.IP.To4()
 
@@ -146,6 +149,9 @@ func (ia *IPAM) Release(subnet *net.IPNet, ipaddr net.IP) error {
 
 	// 将分配位图数组中的索引位置的值置0
 	bitmap := []byte(ia.Subnets[subnet.String()])
+	if offset < 0 || offset >= len(bitmap) {
+		return fmt.Errorf("ip %s is not allocated in subnet %s", ipaddr, subnet)
+	}
 	bitmap[offset] = '0'
 	ia.Subnets[subnet.String()] = string(bitmap)
 
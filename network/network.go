@@ -13,6 +13,7 @@ import (
 	"runtime"
 	"strings"
 	"text/tabwriter"
+	"time"
 
 	"github.com/sirupsen/logrus"
 	"github.com/vishvananda/netlink"
@@ -138,6 +139,39 @@ func DeleteNetwork(networkName string) error {
 	return nw.remove(defaultNetworkPath)
 }
 
+// PruneNetworks 删除没有容器使用、并且在until之前创建的网络，until为零值时不按时间过滤。
+// dryRun时只统计不删除，返回被删除的网络名和网络配置文件占用的空间
+func PruneNetworks(inUse map[string]bool, until time.Time, dryRun bool) ([]string, int64, error) {
+	var (
+		pruned    []string
+		reclaimed int64
+	)
+	for name, nw := range networks {
+		if inUse[name] {
+			continue
+		}
+
+		// 网络配置文件的修改时间就是网络的创建时间
+		info, err := os.Stat(path.Join(defaultNetworkPath, name))
+		if err != nil {
+			return pruned, reclaimed, err
+		}
+		if !until.IsZero() && !info.ModTime().Before(until) {
+			continue
+		}
+
+		if !dryRun {
+			if err := DeleteNetwork(nw.Name); err != nil {
+				return pruned, reclaimed, err
+			}
+			delete(networks, name)
+		}
+		pruned = append(pruned, name)
+		reclaimed += info.Size()
+	}
+	return pruned, reclaimed, nil
+}
+
 func Connect(networkName string, cinfo *container.ContainerInfo) error {
 	// 通过networkName获取对应已经创建的network
 	network, ok := networks[networkName]
@@ -150,6 +184,7 @@ func Connect(networkName string, cinfo *container.ContainerInfo) error {
 	if err != nil {
 		return err
 	}
+	cinfo.IPAddress = ip.String()
 
 	// 创建容器的 网络端点，设置网络端点的IP，端口的映射信息
 	endpoint := &Endpoint{
@@ -173,6 +208,33 @@ func Connect(networkName string, cinfo *container.ContainerInfo) error {
 	return configPortMapping(endpoint)
 }
 
+// Disconnect 将容器从网络上断开，删除端口映射的iptables规则，并释放容器的IP
+func Disconnect(networkName string, cinfo *container.ContainerInfo) error {
+	network, ok := networks[networkName]
+	if !ok {
+		return fmt.Errorf("no such network: %s", networkName)
+	}
+
+	endpoint := &Endpoint{
+		ID:          cinfo.ID + "-" + networkName,
+		IPaddr:      net.ParseIP(cinfo.IPAddress),
+		Network:     network,
+		PortMapping: cinfo.PortMapping,
+	}
+
+	if endpoint.IPaddr == nil {
+		return fmt.Errorf("container %s has no ip in network %s", cinfo.ID, networkName)
+	}
+
+	deletePortMapping(endpoint)
+
+	if err := drivers[network.Driver].Disconnect(*network, endpoint); err != nil {
+		return err
+	}
+
+	return ipAllocator.Release(network.IPRange, endpoint.IPaddr)
+}
+
 // 进入容器的网络namespace，配置容器网络设备的 IP 地址和路由
 func configEndpointIPAddrAndRoute(ep *Endpoint, cinfo *container.ContainerInfo) error {
 	// 通过name获取已经接入Linux Bridge的veth
@@ -260,6 +322,17 @@ func enterContainerNetns(enLink *netlink.Link, cinfo *container.ContainerInfo) f
 }
 
 func configPortMapping(ep *Endpoint) error {
+	iptablesPortMapping("-A", ep)
+	return nil
+}
+
+// deletePortMapping 删除容器的端口映射规则
+func deletePortMapping(ep *Endpoint) {
+	iptablesPortMapping("-D", ep)
+}
+
+// iptablesPortMapping 添加(-A)或删除(-D)端口映射对应的DNAT规则
+func iptablesPortMapping(action string, ep *Endpoint) {
 	for _, pm := range ep.PortMapping {
 		portMapping := strings.Split(pm, ":")
 		if len(portMapping) != 2 {
@@ -269,8 +342,8 @@ func configPortMapping(ep *Endpoint) error {
 
 		// 由于iptables没有go语言的实现，采用exec.Command的方式直接调用命令配置
 		// 在iptables的PREROUTING中添加DNAT规则，将宿主机端口转发到容器的地址端口上
-		iptablesCmd := fmt.Sprintf("-t nat -A PREROUTING -p tcp -m tcp --dport %s -j DNAT --to-destination %s:%s",
-			portMapping[0], ep.IPaddr.String(), portMapping[1])
+		iptablesCmd := fmt.Sprintf("-t nat %s PREROUTING -p tcp -m tcp --dport %s -j DNAT --to-destination %s:%s",
+			action, portMapping[0], ep.IPaddr.String(), portMapping[1])
 
 		subcmds := strings.Split(iptablesCmd, " ")
 		cmd := exec.Command("iptables", subcmds...)
@@ -279,7 +352,6 @@ func configPortMapping(ep *Endpoint) error {
 			continue
 		}
 	}
-	return nil
 }
 
 type Network struct {
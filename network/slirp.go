@@ -0,0 +1,40 @@
+package network
+
+import (
+	"fmt"
+	"os/exec"
+	"strconv"
+)
+
+const (
+	// NoneNetwork 容器只有自己的network namespace，没有连接任何网络
+	NoneNetwork = "none"
+	// SlirpNetwork 使用slirp4netns提供用户态网络，rootless模式下不能创建bridge和iptables规则
+	SlirpNetwork = "slirp4netns"
+
+	// slirp4netns --configure 给容器配置的地址，以及转发到宿主机resolver的DNS地址
+	SlirpIP  = "10.0.2.100"
+	SlirpDNS = "10.0.2.3"
+)
+
+// StartSlirp 在容器的network namespace中创建tap0，由slirp4netns把容器的流量转发到宿主机。
+// 容器使用user namespace时，slirp4netns需要先进入容器的user namespace才有权限操作它的网络。
+// 返回的进程在容器退出后由调用者结束
+func StartSlirp(pid int, userns bool) (*exec.Cmd, error) {
+	slirp, err := exec.LookPath(SlirpNetwork)
+	if err != nil {
+		return nil, fmt.Errorf("%s not found: %w", SlirpNetwork, err)
+	}
+
+	args := []string{"--configure", "--mtu=65520", "--disable-host-loopback"}
+	if userns {
+		args = append(args, fmt.Sprintf("--userns-path=/proc/%d/ns/user", pid))
+	}
+	args = append(args, strconv.Itoa(pid), "tap0")
+
+	cmd := exec.Command(slirp, args...)
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("start %s error: %w", SlirpNetwork, err)
+	}
+	return cmd, nil
+}
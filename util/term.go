@@ -0,0 +1,48 @@
+package util
+
+import (
+	"golang.org/x/sys/unix"
+)
+
+// IsTerminal 判断fd是否是一个终端
+func IsTerminal(fd uintptr) bool {
+	_, err := unix.IoctlGetTermios(int(fd), unix.TCGETS)
+	return err == nil
+}
+
+// MakeRaw 把终端设置成raw模式，输入不再经过行缓冲、回显和信号字符处理，
+// 原样交给容器里的终端处理。返回的函数用于恢复原来的终端设置
+func MakeRaw(fd uintptr) (func(), error) {
+	termios, err := unix.IoctlGetTermios(int(fd), unix.TCGETS)
+	if err != nil {
+		return nil, err
+	}
+	old := *termios
+
+	// 和 cfmakeraw(3) 的设置相同
+	termios.Iflag &^= unix.IGNBRK | unix.BRKINT | unix.PARMRK | unix.ISTRIP | unix.INLCR | unix.IGNCR | unix.ICRNL | unix.IXON
+	termios.Oflag &^= unix.OPOST
+	termios.Lflag &^= unix.ECHO | unix.ECHONL | unix.ICANON | unix.ISIG | unix.IEXTEN
+	termios.Cflag &^= unix.CSIZE | unix.PARENB
+	termios.Cflag |= unix.CS8
+	termios.Cc[unix.VMIN] = 1
+	termios.Cc[unix.VTIME] = 0
+
+	if err := unix.IoctlSetTermios(int(fd), unix.TCSETS, termios); err != nil {
+		return nil, err
+	}
+
+	return func() {
+		_ = unix.IoctlSetTermios(int(fd), unix.TCSETS, &old)
+	}, nil
+}
+
+// CopyWinsize 把src终端的窗口大小设置到dst终端上，
+// 内核会给dst终端的前台进程组发送SIGWINCH
+func CopyWinsize(dst, src uintptr) error {
+	ws, err := unix.IoctlGetWinsize(int(src), unix.TIOCGWINSZ)
+	if err != nil {
+		return err
+	}
+	return unix.IoctlSetWinsize(int(dst), unix.TIOCSWINSZ, ws)
+}
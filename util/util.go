@@ -1,7 +1,12 @@
 package util
 
 import (
+	"fmt"
 	"math/rand"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
 	"time"
 )
 
@@ -16,3 +21,63 @@ func RandStringBytes(n int) string {
 	}
 	return string(b)
 }
+
+// DirSize 统计目录下所有文件占用的字节数，目录不存在时返回0
+func DirSize(dir string) int64 {
+	var size int64
+	_ = filepath.Walk(dir, func(_ string, info os.FileInfo, err error) error {
+		if err != nil {
+			return nil
+		}
+		if !info.IsDir() {
+			size += info.Size()
+		}
+		return nil
+	})
+	return size
+}
+
+// HumanSize 把字节数转换成便于阅读的格式，例如 1.5MB
+func HumanSize(size int64) string {
+	units := []string{"B", "kB", "MB", "GB", "TB"}
+	s := float64(size)
+	i := 0
+	for s >= 1000 && i < len(units)-1 {
+		s /= 1000
+		i++
+	}
+	if i == 0 {
+		return fmt.Sprintf("%dB", size)
+	}
+	return fmt.Sprintf("%.3g%s", s, units[i])
+}
+
+// ParseSize 解析 512k、10m、1g 这样的大小，单位按1024换算，不区分大小写，
+// 可以带b后缀(例如 10mb)，没有单位时表示字节数
+func ParseSize(s string) (int64, error) {
+	str := strings.ToLower(strings.TrimSpace(s))
+	str = strings.TrimSuffix(str, "b")
+
+	multiplier := int64(1)
+	if n := len(str); n > 0 {
+		switch str[n-1] {
+		case 'k':
+			multiplier = 1 << 10
+		case 'm':
+			multiplier = 1 << 20
+		case 'g':
+			multiplier = 1 << 30
+		case 't':
+			multiplier = 1 << 40
+		}
+		if multiplier > 1 {
+			str = str[:n-1]
+		}
+	}
+
+	value, err := strconv.ParseFloat(str, 64)
+	if err != nil || value < 0 {
+		return 0, fmt.Errorf("invalid size: %q", s)
+	}
+	return int64(value * float64(multiplier)), nil
+}
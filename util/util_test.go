@@ -0,0 +1,26 @@
+package util
+
+import "testing"
+
+func TestParseSize(t *testing.T) {
+	tests := map[string]int64{
+		"0":    0,
+		"1024": 1024,
+		"64m":  64 << 20,
+		"64MB": 64 << 20,
+		"1.5m": 1536 << 10,
+		"1g":   1 << 30,
+		"8k":   8 << 10,
+		"2t":   2 << 40,
+	}
+	for s, want := range tests {
+		if got, err := ParseSize(s); err != nil || got != want {
+			t.Errorf("ParseSize(%q) = %d, %v, want %d", s, got, err, want)
+		}
+	}
+	for _, s := range []string{"", "m", "-1m", "12x"} {
+		if _, err := ParseSize(s); err == nil {
+			t.Errorf("ParseSize(%q) expected error", s)
+		}
+	}
+}